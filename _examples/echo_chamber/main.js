@@ -37,6 +37,22 @@ const responder = (req, res) => {
         'version': req.httpVersion,
     }
 
+    // If the "pages" query param is given then we will paginate the response. The current page is given by either the
+    // "page" or the "cursor" query param. The next page is given in the Link header, as well as the "next" and
+    // "next_cursor" fields
+    if (resObj.query_params.pages) {
+        const page = Number(resObj.query_params.cursor || resObj.query_params.page || 1)
+        resObj['next'] = null
+        resObj['next_cursor'] = null
+        if (page < Number(resObj.query_params.pages)) {
+            const next = new URL(fullUrl)
+            next.searchParams.set('page', String(page + 1))
+            res.setHeader('Link', `<${next.toString()}>; rel="next"`)
+            resObj['next'] = next.toString()
+            resObj['next_cursor'] = String(page + 1)
+        }
+    }
+
     const send = () => {
         switch (resObj.query_params.format) {
             case 'html':
@@ -78,6 +94,16 @@ const responder = (req, res) => {
         }
     }
 
+    // If the "delay" query param is given then the response is only sent after that many milliseconds. This can be used
+    // to simulate a slow upstream
+    const reply = () => {
+        if (resObj.query_params.delay) {
+            setTimeout(send, Number(resObj.query_params.delay))
+        } else {
+            send()
+        }
+    }
+
     // We add in the request body if it's one of the HTTP methods that should have a body
     if (REQUEST_HAS_BODY_METHODS.includes(req.method)) {
         let body = "";
@@ -105,10 +131,10 @@ const responder = (req, res) => {
                 }
             }
             resObj['body'] = encoded
-            send()
+            reply()
         })
     } else {
-        send()
+        reply()
     }
 }
 
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"github.com/andygello555/data"
+	"github.com/andygello555/parser"
+	"io"
+	"sync"
+)
+
+// AsyncPool is the pool of workers that executes the work started by the $async builtin. It is shared by a VM and all
+// the VMs forked from it, and limits the amount of work that can be executed at once.
+type AsyncPool struct {
+	// slots is a buffered channel with a capacity of the number of workers in the pool. A worker takes a slot before
+	// executing its work, and gives the slot back once it is done.
+	slots chan struct{}
+}
+
+// NewAsyncPool creates a new AsyncPool with the given number of workers. If this is less than 1 then DefaultWorkers is
+// used instead.
+func NewAsyncPool(workers int) *AsyncPool {
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	return &AsyncPool{slots: make(chan struct{}, workers)}
+}
+
+// Go executes the given function once a worker in the AsyncPool is free, and returns a data.Promise that settles to the
+// function's result. Go never blocks. The context.Context given to the function is cancelled when the data.Promise is
+// cancelled using data.Cancel. If it is cancelled before a worker is free, then the function is never executed and the
+// data.Promise settles to the context.Context's error.
+func (p *AsyncPool) Go(fn func(ctx context.Context) (err error, result *data.Value)) data.Promise {
+	ctx, cancel := context.WithCancel(context.Background())
+	deferred := data.NewCancellableDeferred(cancel)
+	go func() {
+		defer cancel()
+		select {
+		case p.slots <- struct{}{}:
+		case <-ctx.Done():
+			deferred.Complete(ctx.Err(), nil)
+			return
+		}
+		defer func() { <-p.slots }()
+		deferred.Complete(fn(ctx))
+	}()
+	return deferred
+}
+
+// lockedWriter wraps an io.Writer so that it can be written to by a VM and the VMs forked from it at the same time.
+type lockedWriter struct {
+	*sync.Mutex
+	w io.Writer
+}
+
+func (lw *lockedWriter) Write(p []byte) (n int, err error) {
+	lw.Lock()
+	defer lw.Unlock()
+	return lw.w.Write(p)
+}
+
+// locked wraps the given io.Writer in a lockedWriter if the VM has been forked. Otherwise, the io.Writer is returned as
+// it is.
+func (vm *VM) locked(w io.Writer) io.Writer {
+	if vm.output == nil {
+		return w
+	}
+	return &lockedWriter{Mutex: vm.output, w: w}
+}
+
+// Async executes the given function on the VM's AsyncPool.
+func (vm *VM) Async(fn func(ctx context.Context) (err error, result *data.Value)) data.Promise {
+	return vm.Pool.Go(fn)
+}
+
+// Fork creates a new VM that can evaluate code concurrently with the VM. The new VM has a single stack frame that
+// contains a deep copy of the variables on the VM's current stack frame, so any changes that the new VM makes to them
+// will not be seen by the VM. Anonymous functions are given a copy of the variables that they close over for the same
+// reason. The new VM shares the VM's Client, AsyncPool, and environments. The stdout, stderr, and
+// debug io.Writers are also shared, and from then on are only written to whilst holding a lock that is shared by both
+// VMs. Tests within the new VM are not added to the VM's TestResults.
+func (vm *VM) Fork() parser.VM {
+	if vm.output == nil {
+		vm.output = &sync.Mutex{}
+	}
+
+	cs := make(CallStack, 0)
+	fork := &VM{
+		Pos:           vm.Pos,
+		Scope:         vm.Scope,
+		CallStack:     &cs,
+		Stdout:        vm.Stdout,
+		Stderr:        vm.Stderr,
+		Debug:         vm.Debug,
+		Environments:  vm.Environments,
+		Client:        vm.Client,
+		BatchWorkers:  vm.BatchWorkers,
+		Pool:          vm.Pool,
+		BatchProgress: vm.BatchProgress,
+		LoadStats:     vm.LoadStats,
+		Modules:       vm.Modules,
+		importing:     append([]string{}, vm.importing...),
+		output:        vm.output,
+	}
+	// Pushing the bottommost frame never fails
+	_ = fork.CallStack.Call(nil, nil, fork, nil)
+	parser.NewHeapCopier().CopyInto(vm.CallStack.Current().GetHeap(), fork.CallStack.Current().GetHeap())
+	return fork
+}
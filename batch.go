@@ -2,29 +2,170 @@ package main
 
 import (
 	"container/heap"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/andygello555/data"
+	"github.com/andygello555/errors"
+	"github.com/andygello555/eval"
 	"github.com/andygello555/parser"
+	"io"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
-// MaxWorkers are the max number of worker goroutines we can create in our pool.
-const MaxWorkers = 20
+// DefaultWorkers is the number of worker goroutines that are created in the pool of a BatchSuite when no number of
+// workers is given.
+const DefaultWorkers = 20
+
+// WorkersEnv is the environment variable that VMs read their default number of batch workers from.
+const WorkersEnv = "STTP_BATCH_WORKERS"
+
+// ProgressEnv is the environment variable that enables the progress line that is written to stderr by long running
+// batch statements. It can be set to any value accepted by strconv.ParseBool.
+const ProgressEnv = "STTP_BATCH_PROGRESS"
+
+const (
+	// ProgressDelay is how long a BatchSuite must have been running for before its progress line is first written.
+	ProgressDelay = time.Second
+	// ProgressInterval is how often the progress line of a BatchSuite is rewritten.
+	ProgressInterval = 250 * time.Millisecond
+)
+
+// BatchPolicy decides what happens when one of the BatchItems of a BatchSuite fails.
+type BatchPolicy int
+
+const (
+	// WaitPolicy executes every BatchItem. The error of a failed BatchItem is returned when its BatchResult is awaited.
+	WaitPolicy BatchPolicy = iota
+	// FailFastPolicy cancels every BatchItem that is being executed, or is yet to be executed, once a BatchItem fails.
+	FailFastPolicy
+	// CollectAllPolicy executes every BatchItem. A failed BatchItem's BatchResult awaits to null rather than to its
+	// error, and the errors are instead collected using BatchSuite.Collect.
+	CollectAllPolicy
+)
+
+// policyOf returns the BatchPolicy given within the given parser.Batch statement.
+func policyOf(statement *parser.Batch) BatchPolicy {
+	switch {
+	case statement == nil:
+		return WaitPolicy
+	case statement.FailFast:
+		return FailFastPolicy
+	case statement.Collect != nil:
+		return CollectAllPolicy
+	default:
+		return WaitPolicy
+	}
+}
+
+// workerPool is a pool of worker goroutines that each read jobs from the same buffered channel until it is closed. It is
+// used by a BatchSuite to execute its BatchItems, and by a LoadTest to run the iterations of its virtual users.
+type workerPool struct {
+	// jobs is a buffered channel that holds the jobs to execute within the worker goroutines.
+	jobs chan interface{}
+	// group is a sync.WaitGroup that is used to wait until all workers have executed the jobs that they have been
+	// given.
+	group sync.WaitGroup
+}
+
+// newWorkerPool creates a workerPool whose channel of jobs has the given capacity. No workers are started until start
+// is called.
+func newWorkerPool(capacity int) *workerPool {
+	return &workerPool{jobs: make(chan interface{}, capacity)}
+}
+
+// start spins up the given number of worker goroutines. Each one calls the given worker routine with its index and the
+// channel of jobs, and is finished once the worker routine returns.
+func (p *workerPool) start(workers int, worker func(w int, jobs <-chan interface{})) {
+	for w := 0; w < workers; w++ {
+		p.group.Add(1)
+		go func(w int) {
+			defer p.group.Done()
+			worker(w, p.jobs)
+		}(w)
+	}
+}
+
+// stop closes the channel of jobs, then waits for every worker to execute the jobs left within it. This should only be
+// called once.
+func (p *workerPool) stop() {
+	close(p.jobs)
+	p.group.Wait()
+}
 
 // BatchItem is a unit of work that is distributed amongst each methodWorker.
 type BatchItem struct {
 	Method *parser.MethodCall
 	Args   []*data.Value
 	Id     int
+	// Result is the BatchResult that the methodWorker that executes the BatchItem will settle.
+	Result *BatchResult
 }
 
-// BatchResult contains the result for one BatchItem.
+// BatchResult contains the result for one BatchItem. It is also the data.Promise that is returned for the BatchItem
+// when it is enqueued, and it settles once a methodWorker has executed the BatchItem.
 type BatchResult struct {
 	Id     int
 	Method *parser.MethodCall
 	Err    error
 	Value  *data.Value
+	// done is closed once Err and Value have been set.
+	done chan struct{}
+	// observed is set once Err has been returned by Await.
+	observed bool
+	// collected is set if the BatchResult was created by a BatchSuite with the CollectAllPolicy.
+	collected bool
+	// Enqueued is when the BatchItem was added to the work queue.
+	Enqueued time.Time
+	// Started and Finished are when a methodWorker started and finished executing the BatchItem. If the BatchItem was
+	// cancelled before it was executed, then these are both when it was cancelled.
+	Started  time.Time
+	Finished time.Time
+}
+
+// newBatchResult creates an unsettled BatchResult for the given BatchItem, which is about to be enqueued.
+func newBatchResult(item *BatchItem) *BatchResult {
+	return &BatchResult{
+		Id:       item.Id,
+		Method:   item.Method,
+		done:     make(chan struct{}),
+		Enqueued: time.Now(),
+	}
+}
+
+// settle sets the Err and Value of the BatchResult, waking up anything that is awaiting it.
+func (br *BatchResult) settle(err error, value *data.Value) {
+	br.Err, br.Value = err, value
+	close(br.done)
+}
+
+// Await blocks until the BatchResult has been settled by a methodWorker, then returns its Err and Value. If the
+// BatchResult is being collected then a null Value is returned in place of its Err.
+func (br *BatchResult) Await() (err error, value *data.Value) {
+	<-br.done
+	br.observed = true
+	if br.Err != nil && br.collected {
+		return nil, &data.Value{Type: data.Null}
+	}
+	return br.Err, br.Value
+}
+
+// Done returns a channel that is closed once the BatchResult has been settled by a methodWorker.
+func (br *BatchResult) Done() <-chan struct{} {
+	return br.done
+}
+
+// MarshalJSON marshals the Value that the BatchResult settles to. This will block until the BatchResult is settled.
+func (br *BatchResult) MarshalJSON() ([]byte, error) {
+	<-br.done
+	if br.Err != nil || br.Value == nil {
+		return []byte("null"), nil
+	}
+	return json.Marshal(br.Value.Value)
 }
 
 // GetErr will return the Err for this BatchResult.
@@ -80,58 +221,201 @@ type BatchSuite struct {
 	Results BatchResults
 	// CurrentId is a counter for the ID that is given to each enqueued job.
 	CurrentId int
-	// jobChan is a buffered channel that holds the jobs to execute within the worker goroutines.
-	jobChan chan *BatchItem
+	// promised contains the BatchResult of every enqueued job in the order that they were enqueued. Unlike Results,
+	// this is only ever accessed by the interpreter's goroutine.
+	promised []*BatchResult
+	// assigned contains every variable that was assigned to within the batch statement. Variables can be shared between
+	// stack frames, such as the global values of a module or the variables captured by an anonymous function, so they
+	// cannot be settled by only settling the Heap of the stack frame that the batch statement is in.
+	assigned []*data.Value
+	// Client is the eval.Client that the workers will use to make their HTTP method calls.
+	Client *eval.Client
+	// Policy is the BatchPolicy that decides what happens when a BatchItem fails.
+	Policy BatchPolicy
+	// timeout is how long the BatchItems have to finish once the workers have been started. If this is 0 then there is
+	// no deadline.
+	timeout time.Duration
+	// Record is called by the workers once they have made each HTTP method call. It can be nil.
+	Record func(method *parser.MethodCall, elapsed time.Duration, err error)
+	// Progress is the io.Writer that the progress line is written to, once the BatchSuite has been running for longer
+	// than ProgressDelay. If this is nil then no progress line is written.
+	Progress io.Writer
+	// counters contains the counters used for the progress line.
+	counters *batchCounters
+	// progressDone is closed by Stop to stop the goroutine writing the progress line, which then closes progressStopped.
+	progressDone    chan struct{}
+	progressStopped chan struct{}
+	// workers is the number of workers that were started by Start.
+	workers int
+	// started and stopped are when the workers were started by Start, and when they were stopped by Stop.
+	started time.Time
+	stopped time.Time
+	// ctx is the context.Context that each HTTP method call is made with. It is cancelled by cancel once the first
+	// BatchItem fails when using the FailFastPolicy, and once the BatchSuite is stopped.
+	ctx    context.Context
+	cancel context.CancelFunc
+	// cause is the BatchResult of the BatchItem whose failure cancelled ctx. It is guarded by causeMutex.
+	cause      *BatchResult
+	causeMutex sync.Mutex
+	// pool is the workerPool whose workers execute each BatchItem using methodWorker.
+	pool *workerPool
 	// resultChan is a buffered channel that the workers enqueue their results into.
 	resultChan chan *BatchResult
 	// consumerDone is an unbuffered channel used to block the interpreter thread until the consumer has added all the
 	// results to Results.
 	consumerDone chan struct{}
-	// workerGroup is a sync.WaitGroup that is used to wait until all workers have executed the work that they have been
-	// given.
-	workerGroup sync.WaitGroup
 	// close is used to execute the Stop only once, so that no panics occur if the channels (mentioned above) are
 	// already closed.
 	close sync.Once
 }
 
-// Batch creates a new BatchSuite. It creates buffered job and result channels that have a capacity of MaxWorkers.
-func Batch(statement *parser.Batch) *BatchSuite {
+// batchCounters are the counters that are updated atomically as BatchItems are enqueued and executed.
+type batchCounters struct {
+	enqueued int64
+	running  int64
+	finished int64
+	failed   int64
+}
+
+// Batch creates a new BatchSuite. It creates buffered job and result channels that have a capacity of DefaultWorkers. The
+// given eval.Client will be used to make each HTTP method call, if it is nil then each call will use a new Client. The
+// BatchPolicy of the BatchSuite is given by the statement.
+func Batch(statement *parser.Batch, client *eval.Client) *BatchSuite {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &BatchSuite{
 		BatchStatement: statement,
 		Results:        make(BatchResults, 0),
 		CurrentId:      0,
-		jobChan:        make(chan *BatchItem, MaxWorkers),
-		resultChan:     make(chan *BatchResult, MaxWorkers),
+		Client:         client,
+		Policy:         policyOf(statement),
+		counters:       &batchCounters{},
+		ctx:            ctx,
+		cancel:         cancel,
+		pool:           newWorkerPool(DefaultWorkers),
+		resultChan:     make(chan *BatchResult, DefaultWorkers),
 		consumerDone:   make(chan struct{}),
 	}
 }
 
-// methodWorker is the worker routine used within the BatchSuite.Execute function. It reads from a channel of jobs and
-// writes to a channel of results. When finished, the worker decrements a sync.WaitGroup.
-func methodWorker(wg *sync.WaitGroup, jobs <-chan *BatchItem, results chan<- *BatchResult) {
-	defer wg.Done()
-	for j := range jobs {
-		// Call eval.Method.Call for the parser.MethodCall's eval.Method and queue the result and err up in a
-		// BatchResult
-		err, result := j.Method.Method.Call(j.Args...)
-		results <- &BatchResult{
-			Id:     j.Id,
-			Method: j.Method,
-			Err:    err,
-			Value:  result,
+// methodWorker is the worker routine that the BatchSuite's workerPool runs. It reads BatchItems from the given channel
+// of jobs and writes to the BatchSuite's channel of results.
+func methodWorker(b *BatchSuite, jobs <-chan interface{}) {
+	for job := range jobs {
+		j := job.(*BatchItem)
+		// Call eval.Method.CallContext for the parser.MethodCall's eval.Method, unless the BatchSuite has already been
+		// cancelled
+		var err error
+		var value *data.Value
+		cancelled := b.ctx.Err() != nil
+		j.Result.Started = time.Now()
+		if !cancelled {
+			atomic.AddInt64(&b.counters.running, 1)
+			err, value = j.Method.Method.CallContext(b.ctx, b.Client, j.Args...)
+			atomic.AddInt64(&b.counters.running, -1)
+		}
+		j.Result.Finished = time.Now()
+		if !cancelled && b.Record != nil {
+			b.Record(j.Method, j.Result.Finished.Sub(j.Result.Started), err)
+		}
+
+		if (cancelled || err != nil) && b.ctx.Err() == context.DeadlineExceeded {
+			// If the deadline passed before the BatchItem finished, then it timed out regardless of the BatchPolicy
+			err, value = errors.BatchTimeout.Errorf(errors.GetNullVM(), j.Method.String(0), b.timeout.String()), nil
+			if b.Policy == FailFastPolicy {
+				b.fail(j.Result)
+			}
+		} else if b.Policy == FailFastPolicy && (cancelled || err != nil) {
+			// If the BatchItem failed, or was never executed, then it might be the cause of the cancellation
+			if cause := b.fail(j.Result); cause != j.Result {
+				err, value = errors.BatchCancelled.Errorf(errors.GetNullVM(), j.Method.String(0), cause.Method.String(0)), nil
+			}
 		}
+
+		// Settle the BatchResult with the result and err, then queue the BatchResult up to be added to the Results
+		if err != nil {
+			atomic.AddInt64(&b.counters.failed, 1)
+		}
+		atomic.AddInt64(&b.counters.finished, 1)
+		j.Result.settle(err, value)
+		b.resultChan <- j.Result
 	}
 }
 
-// AddWork will enqueue the given parser.MethodCall, and its args, as a BatchItem to be executed by the workers.
-func (b *BatchSuite) AddWork(method *parser.MethodCall, args ...*data.Value) {
-	b.jobChan <- &BatchItem{
+// fail cancels the BatchSuite because of the given failed BatchResult. If the BatchSuite has already been cancelled
+// then nothing happens. The BatchResult that caused the cancellation is returned.
+func (b *BatchSuite) fail(result *BatchResult) *BatchResult {
+	b.causeMutex.Lock()
+	defer b.causeMutex.Unlock()
+	if b.cause == nil {
+		b.cause = result
+		b.cancel()
+	}
+	return b.cause
+}
+
+// AddWork will enqueue the given parser.MethodCall, and its args, as a BatchItem to be executed by the workers. The
+// returned data.Promise will settle to the result of the parser.MethodCall once it has been executed.
+func (b *BatchSuite) AddWork(method *parser.MethodCall, args ...*data.Value) data.Promise {
+	item := &BatchItem{
 		Method: method,
 		Args:   args,
 		Id:     b.CurrentId,
 	}
+	item.Result = newBatchResult(item)
+	item.Result.collected = b.Policy == CollectAllPolicy
+	b.promised = append(b.promised, item.Result)
+	atomic.AddInt64(&b.counters.enqueued, 1)
+	b.pool.jobs <- item
 	b.CurrentId++
+	return item.Result
+}
+
+// Assigned records that the given variable was assigned to within the batch statement, so that it is settled by
+// Settle.
+func (b *BatchSuite) Assigned(variable *data.Value) {
+	b.assigned = append(b.assigned, variable)
+}
+
+// Settle settles every variable that was assigned to within the batch statement, so that no Promises outlive it. This
+// should only be called after Stop.
+func (b *BatchSuite) Settle() {
+	for _, variable := range b.assigned {
+		variable.Settle()
+	}
+}
+
+// Err returns the error of the first enqueued BatchItem that failed, but whose BatchResult was never awaited. When using
+// the FailFastPolicy, only the error of the BatchItem that cancelled the BatchSuite is returned, as every other error
+// is a consequence of it. When using the CollectAllPolicy, no error is returned. This should only be called after Stop.
+func (b *BatchSuite) Err() error {
+	switch b.Policy {
+	case FailFastPolicy:
+		if b.cause != nil && !b.cause.observed {
+			b.cause.observed = true
+			return b.cause.Err
+		}
+		return nil
+	case CollectAllPolicy:
+		return nil
+	}
+
+	for _, result := range b.promised {
+		if !result.observed && result.Err != nil {
+			result.observed = true
+			return result.Err
+		}
+	}
+	return nil
+}
+
+// Collect returns the BatchResult of every enqueued BatchItem in the order that they were enqueued. This should only be
+// called after Stop.
+func (b *BatchSuite) Collect() []parser.BatchResult {
+	results := make([]parser.BatchResult, len(b.promised))
+	for i, result := range b.promised {
+		results[i] = result
+	}
+	return results
 }
 
 // GetStatement will return a pointer to a parser.Batch statement so that it can be compared and or set.
@@ -139,20 +423,31 @@ func (b *BatchSuite) GetStatement() *parser.Batch {
 	return b.BatchStatement
 }
 
+// Within sets the deadline of the BatchSuite to be the given duration after its workers are started. Once the deadline
+// has passed, every BatchItem that is in flight is cancelled, and every BatchItem that has not yet finished fails with
+// an errors.BatchTimeout. This must be called before Start.
+func (b *BatchSuite) Within(timeout time.Duration) {
+	b.timeout = timeout
+}
+
 // Start will spin-up the worker goroutines that will be fed the work accumulated over the course of a batch statement.
-// Can be given the number of workers to spin up, if this is a negative integer, or the number of workers exceeds
-// MaxWorkers, then MaxWorkers will be used instead. A consumer goroutine will pull results from the result channel and
-// push them to the Results heap.
+// Can be given the number of workers to spin up, if this is less than 1 then DefaultWorkers will be used instead. A
+// consumer goroutine will pull results from the result channel and push them to the Results heap.
 func (b *BatchSuite) Start(workers int) {
-	if workers < 0 || workers > MaxWorkers {
-		workers = MaxWorkers
+	if workers < 1 {
+		workers = DefaultWorkers
+	}
+	b.workers = workers
+	b.started = time.Now()
+	if b.timeout > 0 {
+		b.cancel()
+		b.ctx, b.cancel = context.WithTimeout(context.Background(), b.timeout)
 	}
 
 	// We spin up the workers
-	for w := 0; w < workers; w++ {
-		b.workerGroup.Add(1)
-		go methodWorker(&b.workerGroup, b.jobChan, b.resultChan)
-	}
+	b.pool.start(workers, func(_ int, jobs <-chan interface{}) {
+		methodWorker(b, jobs)
+	})
 
 	// Start a consumer goroutine that will consume results and append them to the heap. We only start one consumer
 	// because it does not make sense to try and manage a mutex between several.
@@ -162,16 +457,145 @@ func (b *BatchSuite) Start(workers int) {
 		}
 		b.consumerDone <- struct{}{}
 	}()
+
+	if b.Progress != nil {
+		b.progressDone = make(chan struct{})
+		b.progressStopped = make(chan struct{})
+		go b.progress()
+	}
+}
+
+// progress is the goroutine that writes the progress line to the Progress io.Writer. The line is only written once the
+// BatchSuite has been running for ProgressDelay, and is then rewritten every ProgressInterval until Stop is called.
+func (b *BatchSuite) progress() {
+	defer close(b.progressStopped)
+	delay := time.NewTimer(ProgressDelay)
+	select {
+	case <-delay.C:
+	case <-b.progressDone:
+		delay.Stop()
+		return
+	}
+
+	ticker := time.NewTicker(ProgressInterval)
+	defer ticker.Stop()
+	width := b.writeProgress(0, "")
+	for {
+		select {
+		case <-ticker.C:
+			width = b.writeProgress(width, "")
+		case <-b.progressDone:
+			b.writeProgress(width, "\n")
+			return
+		}
+	}
+}
+
+// writeProgress overwrites the previous progress line, which was the given width, with the current state of the
+// counters followed by the given suffix. The width of the new line is returned.
+func (b *BatchSuite) writeProgress(width int, suffix string) int {
+	line := fmt.Sprintf(
+		"batch at %s: %d/%d finished, %d in flight, %d failed, %.1fs",
+		b.BatchStatement.Pos.String(),
+		atomic.LoadInt64(&b.counters.finished),
+		atomic.LoadInt64(&b.counters.enqueued),
+		atomic.LoadInt64(&b.counters.running),
+		atomic.LoadInt64(&b.counters.failed),
+		time.Since(b.started).Seconds(),
+	)
+	_, _ = fmt.Fprintf(b.Progress, "\r%-*s%s", width, line, suffix)
+	return len(line)
+}
+
+// Stats returns an Object summarising the BatchSuite after it has been stopped. All times within the summary are in
+// milliseconds and the times of each call are relative to when the BatchSuite was started:
+//  {
+//      "requests": 3,
+//      "failed": 0,
+//      "workers": 10,
+//      "max_concurrency": 3,
+//      "wall_ms": 12.3,
+//      "calls": [
+//          {
+//              "call": "$get(\"http://localhost:3000\")",
+//              "failed": false,
+//              "enqueued_ms": 0.1,
+//              "started_ms": 0.2,
+//              "finished_ms": 4.5,
+//              "queued_ms": 0.1,
+//              "duration_ms": 4.3
+//          },
+//          ...
+//      ]
+//  }
+func (b *BatchSuite) Stats() *data.Value {
+	type event struct {
+		at    time.Time
+		delta int
+	}
+	events := make([]event, 0, len(b.promised)*2)
+	calls := make([]interface{}, len(b.promised))
+	failed := 0
+	for i, result := range b.promised {
+		if result.Err != nil {
+			failed++
+		}
+		calls[i] = map[string]interface{}{
+			"call":        result.Method.String(0),
+			"failed":      result.Err != nil,
+			"enqueued_ms": milliseconds(result.Enqueued.Sub(b.started)),
+			"started_ms":  milliseconds(result.Started.Sub(b.started)),
+			"finished_ms": milliseconds(result.Finished.Sub(b.started)),
+			"queued_ms":   milliseconds(result.Started.Sub(result.Enqueued)),
+			"duration_ms": milliseconds(result.Finished.Sub(result.Started)),
+		}
+		// Calls that were cancelled before they were made never ran concurrently with anything
+		if result.Finished.After(result.Started) {
+			events = append(events, event{result.Started, 1}, event{result.Finished, -1})
+		}
+	}
+
+	// Sweep over the start and finish of each call to find the maximum number of calls that were in flight at once.
+	// Calls that finish at the same time as another starts are not counted as overlapping.
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at.Equal(events[j].at) {
+			return events[i].delta < events[j].delta
+		}
+		return events[i].at.Before(events[j].at)
+	})
+	current, maxConcurrency := 0, 0
+	for _, e := range events {
+		if current += e.delta; current > maxConcurrency {
+			maxConcurrency = current
+		}
+	}
+
+	return &data.Value{
+		Value: map[string]interface{}{
+			"requests":        float64(len(b.promised)),
+			"failed":          float64(failed),
+			"workers":         float64(b.workers),
+			"max_concurrency": float64(maxConcurrency),
+			"wall_ms":         milliseconds(b.stopped.Sub(b.started)),
+			"calls":           calls,
+		},
+		Type: data.Object,
+	}
 }
 
 // Stop will close the Batch channel, indicating to the workers that there is no more work to execute. We will also wait
 // for the result consumer to finish.
 func (b *BatchSuite) Stop() heap.Interface {
 	b.close.Do(func() {
-		close(b.jobChan)
-		b.workerGroup.Wait()
+		b.pool.stop()
 		close(b.resultChan)
 		<-b.consumerDone
+		b.stopped = time.Now()
+		b.cancel()
+		if b.Progress != nil {
+			close(b.progressDone)
+			<-b.progressStopped
+		}
 	})
 	return &b.Results
 }
@@ -5,6 +5,7 @@ import (
 	"github.com/andygello555/data"
 	"github.com/andygello555/errors"
 	"github.com/andygello555/parser"
+	"sort"
 	"strings"
 )
 
@@ -51,11 +52,24 @@ type CallStack []*Frame
 
 // Call allocates a new stack Frame with the given caller and function definition and adds it to the top of the stack.
 // Returns an error if there is a stack overflow as well as the allocated stack frame.
-func (cs *CallStack) Call(caller *parser.FunctionCall, current *parser.FunctionDefinition, vm parser.VM, args ...*data.Value) error {
+//
+// The positional args are assigned to the parameters of the function in order, and the named args are assigned to the
+// parameters of the same name. Any parameters that are not given an argument are assigned their default value, which
+// is evaluated within the new Frame, or null if they do not have one. If the function has a rest parameter then it is
+// assigned an Array of the positional args that are left over.
+func (cs *CallStack) Call(caller *parser.FunctionCall, current *parser.FunctionDefinition, vm parser.VM, named map[string]*data.Value, args ...*data.Value) (err error) {
 	if len(*cs) == MaxStackFrames {
 		return errors.StackOverflow.Errorf(vm, MaxStackFrames)
 	}
 
+	// Match up the arguments with the parameters before the Frame is pushed
+	var given []*data.Value
+	if caller != nil && current != nil {
+		if err, given = bindArgs(caller, current, vm, named, args); err != nil {
+			return err
+		}
+	}
+
 	// Put a new Frame onto the stack
 	heap := make(data.Heap)
 	*cs = append(*cs, &Frame{
@@ -69,24 +83,63 @@ func (cs *CallStack) Call(caller *parser.FunctionCall, current *parser.FunctionD
 		},
 	})
 
+	// If the arguments cannot be set on the new Frame, such as when a default value fails, then the Frame is popped
+	// so that it is not leaked
+	defer func() {
+		if err != nil {
+			_, _ = cs.Return(vm)
+		}
+	}()
+
 	// We only do this if this isn't our first stack frame
 	if caller != nil && current != nil {
 		params := current.Body.Parameters
+		rest := current.Body.Rest
 		previous := (*cs)[len(*cs)-2]
-		// If there are more arguments than parameters then we'll return an error
-		if len(args) > len(params) {
-			return errors.MoreArgsThanParams.Errorf(vm, current.JSONPath.String(0), len(params), len(args))
+
+		// Copy over global variables from the previous stack frame. If the function was imported from a module then
+		// the global variables are copied from the module instead.
+		globals := previous.Heap
+		if module := current.Module(); module != nil {
+			globals = module
 		}
 
-		// Copy over global variables from the previous stack frame
-		for name, val := range *previous.Heap {
-			if val.Global {
+		// Anonymous functions instead copy every variable from the stack frame that they were created within, apart
+		// from self and those that will be shadowed by parameters. Variables are copied by reference, so assigning to
+		// them will change them within that stack frame too.
+		captured := current.Closure()
+		shadowed := map[string]bool{"self": true}
+		if captured != nil {
+			globals = captured
+			for _, param := range params {
+				shadowed[*param.JSONPath.Parts[0].Property] = true
+			}
+			if rest != nil {
+				shadowed[*rest.Parts[0].Property] = true
+			}
+		}
+		for name, val := range *globals {
+			if val.Global && captured == nil || captured != nil && !shadowed[name] {
 				heap[name] = val
 			}
 		}
 
-		// Create the self variable on the heap. We do this by finding the JSONPath on the previous frame.
-		self := previous.Heap.Get(*current.JSONPath.Parts[0].Property)
+		// Create the self variable on the heap. We do this by finding the JSONPath on the frame that the globals were
+		// copied from. Anonymous functions are called from a different stack frame to the one they were created
+		// within, so self is found on the previous stack frame using the JSONPath that they were called with if they
+		// have not been assigned to a variable.
+		var self *data.Value
+		switch {
+		case captured == nil:
+			self = globals.Get(*current.JSONPath.Parts[0].Property)
+		case current.JSONPath != nil:
+			self = previous.Heap.Get(*current.JSONPath.Parts[0].Property)
+		default:
+			self = previous.Heap.Get(*caller.JSONPath.Parts[0].Property)
+		}
+		if self == nil {
+			self = &data.Value{Value: nil, Type: data.Null}
+		}
 		if debug, ok := vm.GetDebug(); ok {
 			_, _ = fmt.Fprintf(debug, "after getting self: %s\n", self.String())
 		}
@@ -95,20 +148,7 @@ func (cs *CallStack) Call(caller *parser.FunctionCall, current *parser.FunctionD
 		}
 
 		// Set arguments on the heap
-		for i, param := range params {
-			// Get the value to set the param on the heap to
-			var val *data.Value
-			if i < len(args) {
-				val = args[i]
-			} else {
-				val = &data.Value{
-					Value:    nil,
-					Type:     data.Null,
-					Global:   false,
-					ReadOnly: false,
-				}
-			}
-
+		set := func(param *parser.JSONPath, val *data.Value) error {
 			// Check whether the param's JSONPath starts with "self"
 			err, path := param.Convert(vm)
 			if err != nil {
@@ -124,7 +164,41 @@ func (cs *CallStack) Call(caller *parser.FunctionCall, current *parser.FunctionD
 			pathVal := heap.Get(path[0].(string))
 
 			// Then finally we set the value of the *data.Value
-			if err, pathVal.Value = path.Set(vm, pathVal.Value, val.Value); err != nil {
+			err, pathVal.Value = path.Set(vm, pathVal.Value, val.Value)
+			return err
+		}
+
+		for i, param := range params {
+			// Get the value to set the param on the heap to. Defaults are evaluated within the new Frame, after the
+			// parameters before them have been set, so that they can refer to them.
+			val := given[i]
+			if val == nil && param.Default != nil {
+				var err error
+				if err, val = param.Default.Eval(vm); err != nil {
+					return err
+				}
+			}
+			if val == nil {
+				val = &data.Value{
+					Value:    nil,
+					Type:     data.Null,
+					Global:   false,
+					ReadOnly: false,
+				}
+			}
+			if err := set(param.JSONPath, val); err != nil {
+				return err
+			}
+		}
+
+		if rest != nil {
+			extra := make([]interface{}, 0)
+			if len(args) > len(params) {
+				for _, arg := range args[len(params):] {
+					extra = append(extra, arg.Value)
+				}
+			}
+			if err := set(rest, &data.Value{Value: extra, Type: data.Array}); err != nil {
 				return err
 			}
 		}
@@ -132,6 +206,58 @@ func (cs *CallStack) Call(caller *parser.FunctionCall, current *parser.FunctionD
 	return nil
 }
 
+// bindArgs matches up the given positional and named args with the parameters of the given function. The i-th value
+// returned is the argument for the i-th parameter, and is nil if no argument was given for that parameter.
+//
+// Returns an errors.MoreArgsThanParams if there are more positional args than parameters and the function does not
+// have a rest parameter. Returns an errors.ArgumentError if a named arg does not match a parameter, or matches a
+// parameter that has already been given a positional arg.
+func bindArgs(caller *parser.FunctionCall, current *parser.FunctionDefinition, vm parser.VM, named map[string]*data.Value, args []*data.Value) (err error, given []*data.Value) {
+	params := current.Body.Parameters
+	if len(args) > len(params) && current.Body.Rest == nil {
+		return errors.MoreArgsThanParams.Errorf(vm, current.Name(), len(params), len(args)), nil
+	}
+
+	// Names are checked in order so that the same error is always returned
+	names := make([]string, 0, len(named))
+	for name := range named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	given = make([]*data.Value, len(params))
+	copy(given, args)
+	for _, name := range names {
+		val := named[name]
+		for _, arg := range caller.Arguments {
+			if arg.Name != nil && *arg.Name == name {
+				vm.SetPos(arg.GetPos())
+			}
+		}
+
+		found := false
+		for i, param := range params {
+			if param.JSONPath.String(0) != name {
+				continue
+			}
+			if given[i] != nil {
+				return errors.ArgumentError.Errorf(vm, name, caller.JSONPath.String(0), "parameter has already been given a positional argument"), nil
+			}
+			given[i], found = val, true
+			break
+		}
+
+		if !found {
+			reason := "function has no parameter with that name"
+			if current.Body.Rest != nil && current.Body.Rest.String(0) == name {
+				reason = "rest parameters cannot be given by name"
+			}
+			return errors.ArgumentError.Errorf(vm, name, caller.JSONPath.String(0), reason), nil
+		}
+	}
+	return nil, given
+}
+
 // Current returns the currently "running" Frame and doesn't pop it.
 func (cs *CallStack) Current() parser.Frame {
 	return (*cs)[len(*cs)-1]
@@ -166,7 +292,7 @@ func (cs *CallStack) String() string {
 		parentFrame := top[i-1]
 		parentCurrent := ""
 		if parentFrame.Current != nil {
-			parentCurrent = fmt.Sprintf(", in %s", parentFrame.Current.JSONPath.String(0))
+			parentCurrent = fmt.Sprintf(", in %s", parentFrame.Current.Name())
 		}
 
 		caller := ""
@@ -4,8 +4,110 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
+// counter is a LazyIterable which generates the numbers from 0 up to, but not including, itself.
+type counter int
+
+func (c counter) Generate() Generator {
+	i := 0
+	return func() (err error, elem *Element) {
+		if i >= int(c) {
+			return nil, nil
+		}
+		elem = &Element{&Value{float64(i), Number, false, true}, &Value{float64(i), Number, false, true}}
+		i++
+		return nil, elem
+	}
+}
+
+// settled is a Promise which has already settled to the given Value or error.
+type settled struct {
+	err   error
+	value *Value
+}
+
+func (s *settled) Await() (err error, value *Value) { return s.err, s.value }
+
+func (s *settled) Done() <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func TestResolve(t *testing.T) {
+	failed := &settled{err: fmt.Errorf("request failed")}
+	for testNo, test := range []struct {
+		input    interface{}
+		expected interface{}
+		settled  interface{}
+		err      error
+	}{
+		{
+			input:    &settled{value: &Value{Value: map[string]interface{}{"code": 200.0}, Type: Object}},
+			expected: map[string]interface{}{"code": 200.0},
+			settled:  map[string]interface{}{"code": 200.0},
+		},
+		{
+			input: map[string]interface{}{
+				"a": []interface{}{&settled{value: &Value{Value: 1.0, Type: Number}}, "b"},
+				"c": &settled{value: &Value{Value: &settled{value: &Value{Value: true, Type: Boolean}}}},
+			},
+			expected: map[string]interface{}{"a": []interface{}{1.0, "b"}, "c": true},
+			settled:  map[string]interface{}{"a": []interface{}{1.0, "b"}, "c": true},
+		},
+		{
+			input:    []interface{}{1.0, failed},
+			expected: []interface{}{1.0, failed},
+			settled:  []interface{}{1.0, nil},
+			err:      fmt.Errorf("request failed"),
+		},
+		{
+			input:    "no promises",
+			expected: "no promises",
+			settled:  "no promises",
+		},
+	} {
+		err, actual := Resolve(test.input)
+		if test.err != nil {
+			if err == nil || err.Error() != test.err.Error() {
+				t.Errorf("error \"%v\" for testNo: %d does not match the required error: \"%s\"", err, testNo + 1, test.err.Error())
+			}
+		} else if err != nil {
+			t.Errorf("error \"%s\" should not have occurred (testNo: %d)", err.Error(), testNo + 1)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("resolved value %v does not match expected: %v (testNo: %d)", actual, test.expected, testNo + 1)
+		}
+		if actual = Settle(actual); !reflect.DeepEqual(actual, test.settled) {
+			t.Errorf("settled value %v does not match expected: %v (testNo: %d)", actual, test.settled, testNo + 1)
+		}
+	}
+}
+
+func TestAwaitWithin(t *testing.T) {
+	pending := NewDeferred()
+	if _, _, ok := AwaitWithin(pending, time.Millisecond); ok {
+		t.Errorf("pending Deferred should not have settled within the timeout")
+	}
+	if err, _ := Resolve(pending); err != nil {
+		t.Errorf("resolving a Deferred should not await it, but got error \"%s\"", err.Error())
+	}
+
+	go pending.Complete(nil, &Value{Value: 1.0, Type: Number})
+	err, value, ok := AwaitWithin(pending, time.Second)
+	if !ok || err != nil || value.Value != 1.0 {
+		t.Errorf("Deferred settled to (%v, %v, %t), expected (<nil>, 1, true)", err, value, ok)
+	}
+
+	failed := NewDeferred()
+	failed.Complete(fmt.Errorf("request failed"), nil)
+	if err, _, ok = AwaitWithin(failed, 0); !ok || err == nil || err.Error() != "request failed" {
+		t.Errorf("failed Deferred settled to (%v, %t), expected (request failed, true)", err, ok)
+	}
+}
+
 func TestIterate(t *testing.T) {
 	for testNo, test := range []struct{
 		input    *Value
@@ -27,7 +129,7 @@ func TestIterate(t *testing.T) {
 				Global:   false,
 				ReadOnly: false,
 			},
-			expected: &Iterator{
+			expected: &Iterator{elements: elements{
 				{&Value{"a", String, false, true}, &Value{float64(0), Number, false, true}},
 				{&Value{"b", String, false, true}, &Value{float64(1), Number, false, true}},
 				{&Value{"c", String, false, true}, &Value{float64(2), Number, false, true}},
@@ -35,7 +137,7 @@ func TestIterate(t *testing.T) {
 				{&Value{"e", String, false, true}, &Value{float64(4), Number, false, true}},
 				{&Value{"f", String, false, true}, &Value{float64(5), Number, false, true}},
 				{&Value{"g", String, false, true}, &Value{float64(6), Number, false, true}},
-			},
+			}},
 			err: nil,
 		},
 		{
@@ -45,11 +147,11 @@ func TestIterate(t *testing.T) {
 				Global:   false,
 				ReadOnly: false,
 			},
-			expected: &Iterator{
+			expected: &Iterator{elements: elements{
 				{&Value{float64(0), Number, false, true}, &Value{"a", String, false, true}},
 				{&Value{float64(1), Number, false, true}, &Value{"b", String, false, true}},
 				{&Value{float64(2), Number, false, true}, &Value{"c", String, false, true}},
-			},
+			}},
 			err: nil,
 		},
 		{
@@ -59,11 +161,25 @@ func TestIterate(t *testing.T) {
 				Global:   false,
 				ReadOnly: false,
 			},
-			expected: &Iterator{
+			expected: &Iterator{elements: elements{
 				{&Value{float64(0), Number, false, true}, &Value{"a", String, false, true}},
 				{&Value{float64(1), Number, false, true}, &Value{"b", String, false, true}},
 				{&Value{float64(2), Number, false, true}, &Value{"c", String, false, true}},
+			}},
+			err: nil,
+		},
+		{
+			input: &Value{
+				Value:    counter(3),
+				Type:     Iterable,
+				Global:   false,
+				ReadOnly: false,
 			},
+			expected: &Iterator{elements: elements{
+				{&Value{float64(0), Number, false, true}, &Value{float64(0), Number, false, true}},
+				{&Value{float64(1), Number, false, true}, &Value{float64(1), Number, false, true}},
+				{&Value{float64(2), Number, false, true}, &Value{float64(2), Number, false, true}},
+			}},
 			err: nil,
 		},
 	}{
@@ -80,19 +196,26 @@ func TestIterate(t *testing.T) {
 			t.Errorf("error \"%s\" should not have occurred (testNo: %d)", err.Error(), testNo + 1)
 		}
 
-		if actual.Len() == test.expected.Len() {
-			i := 0
-			for actual.Len() > 0 {
-				elemActual := actual.Next()
-				elemExpected := test.expected.Next()
-				if testing.Verbose() {
-					fmt.Printf("\t%d: (k: %v, v: %v) vs (k: %v, v: %v)\n", i, elemActual.Key.Value, elemActual.Val.Value, elemExpected.Key.Value, elemExpected.Val.Value)
-				}
-				if !reflect.DeepEqual(elemActual.Key.Value, elemExpected.Key.Value) || !reflect.DeepEqual(elemActual.Val.Value, elemExpected.Val.Value) {
-					t.Errorf("element %d: (k: %v, v: %v) does not match expected: (k: %v, v: %v)", i, elemActual.Key.Value, elemActual.Val.Value, elemExpected.Key.Value, elemExpected.Val.Value)
-				}
-				i ++
+		// The length of an Iterator over an Iterable is not known up front, so we iterate until the expected Iterator
+		// has been exhausted instead of comparing lengths.
+		i := 0
+		for test.expected.Len() > 0 {
+			elemExpected := test.expected.Next()
+			elemActual := actual.Next()
+			if elemActual == nil {
+				t.Errorf("element %d: expected (k: %v, v: %v) but the iterator was exhausted (testNo: %d)", i, elemExpected.Key.Value, elemExpected.Val.Value, testNo + 1)
+				break
 			}
+			if testing.Verbose() {
+				fmt.Printf("\t%d: (k: %v, v: %v) vs (k: %v, v: %v)\n", i, elemActual.Key.Value, elemActual.Val.Value, elemExpected.Key.Value, elemExpected.Val.Value)
+			}
+			if !reflect.DeepEqual(elemActual.Key.Value, elemExpected.Key.Value) || !reflect.DeepEqual(elemActual.Val.Value, elemExpected.Val.Value) {
+				t.Errorf("element %d: (k: %v, v: %v) does not match expected: (k: %v, v: %v)", i, elemActual.Key.Value, elemActual.Val.Value, elemExpected.Key.Value, elemExpected.Val.Value)
+			}
+			i ++
+		}
+		if actual.Len() > 0 {
+			t.Errorf("iterator has more elements than expected (testNo: %d)", testNo + 1)
 		}
 	}
 }
@@ -147,6 +147,10 @@ func (t *Type) Get(value interface{}) (err error) {
 		*t = Array
 	case map[string]interface{}:
 		*t = Object
+	case LazyIterable:
+		*t = Iterable
+	case Promise:
+		*t = Future
 	default:
 		// Using reflection we find the name of the value's type to see if it is a FunctionDefinition
 		if strings.Contains(reflect.TypeOf(value).String(), "FunctionDefinition") {
@@ -178,6 +182,10 @@ const (
 	// Function cannot be stored in a variable per-say but is put on the heap as a symbol. A symbol which has a Function
 	// type has a value which points to a FunctionBody struct.
 	Function
+	// Iterable is a value which implements LazyIterable. Its elements are generated lazily when it is iterated over.
+	Iterable
+	// Future is a value which implements Promise. It settles to a value of any other Type once it has been computed.
+	Future
 )
 
 var Types = map[Type]bool{
@@ -189,6 +197,8 @@ var Types = map[Type]bool{
 	Boolean:  true,
 	Null:     true,
 	Function: true,
+	Iterable: true,
+	Future:   true,
 }
 
 var typeNames = map[Type]string{
@@ -200,4 +210,27 @@ var typeNames = map[Type]string{
 	Boolean:  "bool",
 	Null:     "null",
 	Function: "function",
+	Iterable: "iterable",
+	Future:   "future",
+}
+
+// Copy returns a deep copy of the given value. Objects and Arrays are copied recursively, whereas all other values are
+// returned as they are.
+func Copy(value interface{}) interface{} {
+	switch value.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(value.(map[string]interface{})))
+		for key, elem := range value.(map[string]interface{}) {
+			obj[key] = Copy(elem)
+		}
+		return obj
+	case []interface{}:
+		arr := make([]interface{}, len(value.([]interface{})))
+		for i, elem := range value.([]interface{}) {
+			arr[i] = Copy(elem)
+		}
+		return arr
+	default:
+		return value
+	}
 }
@@ -14,50 +14,88 @@ type Element struct {
 	Val *Value
 }
 
-type Iterator []*Element
+// Generator yields the next Element of a LazyIterable each time it is called. Once there are no more elements a nil
+// Element is returned.
+type Generator func() (err error, elem *Element)
 
-func (it Iterator) Len() int { return len(it) }
+// LazyIterable is implemented by the values of Iterable Type. Each call to Generate should return a fresh Generator
+// that starts from the first Element, so that the same value can be iterated over more than once.
+type LazyIterable interface {
+	Generate() Generator
+}
+
+// elements is a heap of Element(s) ordered by their keys.
+type elements []*Element
+
+func (es elements) Len() int { return len(es) }
 
-func (it Iterator) Less(i, j int) bool {
-	switch it[i].Key.Type {
+func (es elements) Less(i, j int) bool {
+	switch es[i].Key.Type {
 	case Number:
-		return it[i].Key.Value.(float64) < it[j].Key.Value.(float64)
+		return es[i].Key.Value.(float64) < es[j].Key.Value.(float64)
 	case String:
-		return strings.Compare(it[i].Key.StringLit(), it[j].Key.Value.(string)) <= 0
+		return strings.Compare(es[i].Key.StringLit(), es[j].Key.Value.(string)) <= 0
 	default:
-		panic(fmt.Errorf("cannot have iterator with keys of type: %s", it[i].Key.Type.String()))
+		panic(fmt.Errorf("cannot have iterator with keys of type: %s", es[i].Key.Type.String()))
 	}
 }
 
-func (it Iterator) Swap(i, j int) { it[i], it[j] = it[j], it[i] }
+func (es elements) Swap(i, j int) { es[i], es[j] = es[j], es[i] }
 
-func (it *Iterator) Push(x interface{}) { *it = append(*it, x.(*Element)) }
+func (es *elements) Push(x interface{}) { *es = append(*es, x.(*Element)) }
 
-func (it *Iterator) Pop() interface{} {
-	old := *it
+func (es *elements) Pop() interface{} {
+	old := *es
 	n := len(old)
 	elem := old[n-1]
 	old[n-1] = nil // avoid memory leak
-	*it = old[0 : n-1]
+	*es = old[0 : n-1]
 	return elem
 }
 
+// Iterator iterates over the Element(s) of a String, Object, Array, or Iterable. The Element(s) of an Iterable are only
+// generated when they are needed.
+type Iterator struct {
+	elements  elements
+	generator Generator
+	err       error
+}
+
+// Len returns the number of Element(s) left to iterate over. When iterating over an Iterable, this will generate the
+// next Element if there are no buffered Element(s), and so will only ever return 0 or 1.
+func (it *Iterator) Len() int {
+	if len(it.elements) == 0 && it.generator != nil && it.err == nil {
+		var elem *Element
+		if it.err, elem = it.generator(); it.err == nil && elem != nil {
+			it.elements = append(it.elements, elem)
+		} else {
+			// Once the generator has been exhausted, or has errored, we will no longer call it
+			it.generator = nil
+		}
+	}
+	return len(it.elements)
+}
+
 func (it *Iterator) Next() *Element {
 	if it.Len() > 0 {
-		if (*it)[0].Key.Type == String {
-			return heap.Pop(it).(*Element)
+		if it.elements[0].Key.Type == String {
+			return heap.Pop(&it.elements).(*Element)
 		} else {
-			old := *it
+			old := it.elements
 			elem := old[0]
 			old[0] = nil
-			*it = old[1:]
+			it.elements = old[1:]
 			return elem
 		}
 	}
 	return nil
 }
 
-// Iterate will construct an iterator from the given Value. This Value must be of Type: String, Object, or Array.
+// Err returns the error that was returned by the Generator of an Iterable, if there was one.
+func (it *Iterator) Err() error { return it.err }
+
+// Iterate will construct an iterator from the given Value. This Value must be of Type: String, Object, Array, or
+// Iterable.
 func Iterate(result *Value) (err error, it *Iterator) {
 	defer func() {
 		if p := recover(); p != nil {
@@ -82,10 +120,10 @@ func Iterate(result *Value) (err error, it *Iterator) {
 	switch result.Type {
 	case Object:
 		obj := result.Value.(map[string]interface{})
-		iterator = make(Iterator, 0)
+		iterator.elements = make(elements, 0)
 
 		for k, v := range obj {
-			heap.Push(&iterator, &Element{
+			heap.Push(&iterator.elements, &Element{
 				Key: &Value{
 					Value:    k,
 					Type:     String,
@@ -102,10 +140,10 @@ func Iterate(result *Value) (err error, it *Iterator) {
 		}
 	case Array:
 		arr := result.Value.([]interface{})
-		iterator = make(Iterator, len(arr))
+		iterator.elements = make(elements, len(arr))
 
 		for i, v := range arr {
-			iterator[i] = &Element{
+			iterator.elements[i] = &Element{
 				Key: &Value{
 					Value:    float64(i),
 					Type:     Number,
@@ -122,10 +160,10 @@ func Iterate(result *Value) (err error, it *Iterator) {
 		}
 	case String:
 		str := result.StringLit()
-		iterator = make(Iterator, len(str))
+		iterator.elements = make(elements, len(str))
 
 		for i, v := range str {
-			iterator[i] = &Element{
+			iterator.elements[i] = &Element{
 				Key: &Value{
 					Value:    float64(i),
 					Type:     Number,
@@ -140,6 +178,8 @@ func Iterate(result *Value) (err error, it *Iterator) {
 				},
 			}
 		}
+	case Iterable:
+		iterator.generator = result.Value.(LazyIterable).Generate()
 	}
 	return nil, &iterator
 }
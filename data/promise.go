@@ -0,0 +1,179 @@
+package data
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Promise is a value that is still being computed. It can be stored within a Value, or be nested within an Object or
+// an Array, in place of the value that it will eventually settle to.
+type Promise interface {
+	// Await blocks until the Promise has settled, and returns the Value that it settled to, or the error that it failed
+	// with.
+	Await() (err error, value *Value)
+	// Done returns a channel that is closed once the Promise has settled.
+	Done() <-chan struct{}
+}
+
+// AwaitWithin is similar to Promise.Await, but gives up waiting once the given timeout has elapsed. If the timeout is
+// not positive then it will wait forever. ok is false if the timeout elapsed before the Promise settled.
+func AwaitWithin(promise Promise, timeout time.Duration) (err error, value *Value, ok bool) {
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case <-promise.Done():
+		case <-timer.C:
+			return nil, nil, false
+		}
+	}
+	err, value = promise.Await()
+	return err, value, true
+}
+
+// Canceller is implemented by Promises whose work can be cancelled once nothing is waiting for them anymore.
+type Canceller interface {
+	// Cancel cancels the work that will settle the Promise. The Promise will still settle once the work has stopped,
+	// usually to the error that the work was cancelled with.
+	Cancel()
+}
+
+// Cancel cancels the given Promise if it implements Canceller. Otherwise, it does nothing.
+func Cancel(promise Promise) {
+	if canceller, ok := promise.(Canceller); ok {
+		canceller.Cancel()
+	}
+}
+
+// Deferred is a Promise that is settled by calling Complete. Use NewDeferred, or NewCancellableDeferred, to create one.
+type Deferred struct {
+	done   chan struct{}
+	err    error
+	value  *Value
+	cancel func()
+}
+
+// NewDeferred creates a Deferred that has not yet settled.
+func NewDeferred() *Deferred {
+	return &Deferred{done: make(chan struct{})}
+}
+
+// NewCancellableDeferred creates a Deferred that has not yet settled, which calls the given function when it is
+// cancelled.
+func NewCancellableDeferred(cancel func()) *Deferred {
+	return &Deferred{done: make(chan struct{}), cancel: cancel}
+}
+
+// Cancel calls the function that the Deferred was created with using NewCancellableDeferred. Otherwise, it does
+// nothing.
+func (d *Deferred) Cancel() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+}
+
+// Complete settles the Deferred to the given error and Value. Complete should only be called once.
+func (d *Deferred) Complete(err error, value *Value) {
+	d.err, d.value = err, value
+	close(d.done)
+}
+
+func (d *Deferred) Await() (err error, value *Value) {
+	<-d.done
+	return d.err, d.value
+}
+
+func (d *Deferred) Done() <-chan struct{} { return d.done }
+
+// MarshalJSON is used for marshalling Deferreds to JSON strings as they appear in the Heap. A Deferred that has not yet
+// settled is marshalled as "future:pending", one that failed as "future:failed", and one that succeeded as the value
+// that it settled to.
+func (d *Deferred) MarshalJSON() ([]byte, error) {
+	select {
+	case <-d.done:
+		if d.err != nil {
+			return json.Marshal("future:failed")
+		}
+		if d.value == nil {
+			return []byte("null"), nil
+		}
+		return json.Marshal(d.value.Value)
+	default:
+		return json.Marshal("future:pending")
+	}
+}
+
+// Resolve awaits every Promise within the given value, replacing each one with the value that it settled to. Objects
+// and Arrays are resolved in place so that each Promise only has to be awaited once. If a Promise fails then its error
+// is returned and the Promise is left in place, so that its error will be returned again when it is next resolved.
+// Deferreds are only ever awaited explicitly, so they are also left in place.
+func Resolve(value interface{}) (err error, resolved interface{}) {
+	switch value.(type) {
+	case *Deferred:
+		return nil, value
+	case Promise:
+		var settled *Value
+		if err, settled = value.(Promise).Await(); err != nil {
+			return err, value
+		}
+		if settled == nil {
+			return nil, nil
+		}
+		return Resolve(settled.Value)
+	case map[string]interface{}:
+		obj := value.(map[string]interface{})
+		for key, elem := range obj {
+			if err, resolved = Resolve(elem); err != nil {
+				return err, value
+			}
+			obj[key] = resolved
+		}
+	case []interface{}:
+		arr := value.([]interface{})
+		for i, elem := range arr {
+			if err, resolved = Resolve(elem); err != nil {
+				return err, value
+			}
+			arr[i] = resolved
+		}
+	}
+	return nil, value
+}
+
+// Settle is similar to Resolve, but a Promise that fails is replaced with nil rather than returning its error. This is
+// used to remove every Promise, other than Deferreds, from a value once their errors have been dealt with.
+func Settle(value interface{}) interface{} {
+	switch value.(type) {
+	case *Deferred:
+		return value
+	case Promise:
+		if err, settled := value.(Promise).Await(); err == nil && settled != nil {
+			return Settle(settled.Value)
+		}
+		return nil
+	case map[string]interface{}:
+		obj := value.(map[string]interface{})
+		for key, elem := range obj {
+			obj[key] = Settle(elem)
+		}
+	case []interface{}:
+		arr := value.([]interface{})
+		for i, elem := range arr {
+			arr[i] = Settle(elem)
+		}
+	}
+	return value
+}
+
+// Settle calls Settle on the value of the Value, and updates its Type to match.
+func (v *Value) Settle() {
+	v.Value = Settle(v.Value)
+	_ = v.Type.Get(v.Value)
+}
+
+// Settle calls Settle on the value of every variable on the Heap.
+func (h *Heap) Settle() {
+	for _, variable := range *h {
+		variable.Settle()
+	}
+}
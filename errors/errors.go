@@ -40,18 +40,29 @@ func (p ProtoSttpError) UpdateVM(vm VM) {
 type RuntimeError string
 
 const (
-	StackOverflow             RuntimeError = "exceeded the maximum number of stack frames (%d)"
-	StackUnderFlow            RuntimeError = "exceeded the minimum number of stack frames (%d)"
-	CannotFindType            RuntimeError = "cannot find type for value \"%v\""
-	CannotCast                RuntimeError = "cannot cast type %s to %s"
-	CannotFindLength          RuntimeError = "cannot find length of value \"%v\""
-	InvalidOperation          RuntimeError = "cannot carry out operation \"%s\" for %s and %s"
-	StringManipulationError   RuntimeError = "error whilst manipulating \"%s\": %s"
-	JSONPathError             RuntimeError = "cannot access %s with %s"
-	Uncallable                RuntimeError = "cannot call value of type %s"
-	MoreArgsThanParams        RuntimeError = "function %s has %d parameters, there were %d arguments provided"
-	MethodParamNotOptional    RuntimeError = "method parameter \"%s\" is not optional"
-	MethodCallMismatchInBatch RuntimeError = "pointer to result for method call: \"%s\" does not match current method call: \"%s\""
+	StackOverflow           RuntimeError = "exceeded the maximum number of stack frames (%d)"
+	StackUnderFlow          RuntimeError = "exceeded the minimum number of stack frames (%d)"
+	CannotFindType          RuntimeError = "cannot find type for value \"%v\""
+	CannotCast              RuntimeError = "cannot cast type %s to %s"
+	CannotFindLength        RuntimeError = "cannot find length of value \"%v\""
+	InvalidOperation        RuntimeError = "cannot carry out operation \"%s\" for %s and %s"
+	InvalidUnaryOperation   RuntimeError = "cannot carry out unary operation \"%s\" for %s"
+	StringManipulationError RuntimeError = "error whilst manipulating \"%s\": %s"
+	JSONPathError           RuntimeError = "cannot access %s with %s"
+	Uncallable              RuntimeError = "cannot call value of type %s"
+	MoreArgsThanParams      RuntimeError = "function %s has %d parameters, there were %d arguments provided"
+	ArgumentError           RuntimeError = "cannot pass argument %s to %s: %s"
+	MethodParamNotOptional  RuntimeError = "method parameter \"%s\" is not optional"
+	PaginationError         RuntimeError = "cannot paginate %s: %s"
+	InvalidMethodOption     RuntimeError = "invalid method call option \"%s\": %s"
+	InvalidBatchWorkers     RuntimeError = "cannot start batch with %s workers, there must be at least 1"
+	BatchCancelled          RuntimeError = "%s was cancelled because %s failed"
+	InvalidBatchDeadline    RuntimeError = "cannot start batch within %s seconds, the deadline must be positive"
+	BatchTimeout            RuntimeError = "%s did not finish within the batch deadline of %s"
+	AsyncError              RuntimeError = "cannot call %s asynchronously: %s"
+	AwaitError              RuntimeError = "cannot await %s: %s"
+	ParallelError           RuntimeError = "cannot run %s in parallel: %s"
+	ImportError             RuntimeError = "cannot import %s: %s"
 )
 
 // runtimeErrorNames contains the names of each RuntimeError enum value.
@@ -62,12 +73,23 @@ var runtimeErrorNames = map[RuntimeError]string{
 	CannotCast: "CannotCast",
 	CannotFindLength: "CannotFindLength",
 	InvalidOperation: "InvalidOperation",
+	InvalidUnaryOperation: "InvalidUnaryOperation",
 	StringManipulationError: "StringManipulationError",
 	JSONPathError: "JSONPathError",
 	Uncallable: "Uncallable",
 	MoreArgsThanParams: "MoreArgsThanParams",
+	ArgumentError: "ArgumentError",
 	MethodParamNotOptional: "MethodParamNotOptional",
-	MethodCallMismatchInBatch: "MethodCallMismatchInBatch",
+	PaginationError: "PaginationError",
+	InvalidMethodOption: "InvalidMethodOption",
+	InvalidBatchWorkers: "InvalidBatchWorkers",
+	BatchCancelled: "BatchCancelled",
+	InvalidBatchDeadline: "InvalidBatchDeadline",
+	BatchTimeout: "BatchTimeout",
+	AsyncError: "AsyncError",
+	AwaitError: "AwaitError",
+	ParallelError: "ParallelError",
+	ImportError: "ImportError",
 }
 
 // Errorf will return an anonymous struct implementing ProtoSttpError with an error method that returns the format 
@@ -113,6 +135,7 @@ const (
 	HeapEntryDoesNotExist StructureError = "cannot %s %s (scope: %d), as \"%s\" is not an entry in symbol table"
 	HeapScopeDoesNotExist StructureError = "cannot %s %s (scope: %d), as scope: %d does not exist in the scope list for the symbol \"%s\""
 	BreakOutsideLoop      StructureError = "break statement is outside of loop"
+	ContinueOutsideLoop   StructureError = "continue statement is outside of loop"
 )
 
 var structureErrorNames = map[StructureError]string{
@@ -122,6 +145,7 @@ var structureErrorNames = map[StructureError]string{
 	HeapEntryDoesNotExist: "HeapEntryDoesNotExist",
 	HeapScopeDoesNotExist: "HeapScopeDoesNotExist",
 	BreakOutsideLoop: "BreakOutsideLoop",
+	ContinueOutsideLoop: "ContinueOutsideLoop",
 }
 
 func (se StructureError) Errorf(vm VM, values... interface{}) error {
@@ -135,6 +159,7 @@ const (
 	Throw
 	FailedTest
 	Break
+	Continue
 )
 
 var purposefulErrorName = map[PurposefulError]string{
@@ -142,6 +167,7 @@ var purposefulErrorName = map[PurposefulError]string{
 	Throw:      "throw statement",
 	FailedTest: "failed test statement",
 	Break:      "break statement",
+	Continue:   "continue statement",
 }
 
 func (pe PurposefulError) Error() string { return purposefulErrorName[pe] }
@@ -205,7 +231,8 @@ func ConstructSttpError(err error, userErr interface{}) (errVal interface{}, ret
 			errVal = userErr
 		case FailedTest: fallthrough
 		case Return: fallthrough
-		case Break:
+		case Break: fallthrough
+		case Continue:
 			return err, true
 		default:
 			errVal = map[string]interface{} {
@@ -0,0 +1,182 @@
+package eval
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheEntry is a single response that is stored within the Cache.
+type cacheEntry struct {
+	// vary contains the values of the request headers that were named in the Vary header of the response. A request
+	// will only match this entry if it has the same values for each of these headers.
+	vary     map[string]string
+	response *response
+	// stored is when the response was stored, or last revalidated.
+	stored time.Time
+}
+
+// matches checks whether the request headers given match the request headers that the entry was stored with.
+func (e *cacheEntry) matches(header http.Header) bool {
+	for name, value := range e.vary {
+		if header.Get(name) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// fresh checks whether the entry can be served without revalidating it with the server. This is only the case when
+// the response had a Cache-Control max-age directive that has not yet elapsed, and did not have a no-cache directive.
+func (e *cacheEntry) fresh(now time.Time) bool {
+	directives := cacheControl(e.response.header)
+	if _, ok := directives["no-cache"]; ok {
+		return false
+	}
+	if maxAge, ok := directives["max-age"]; ok {
+		if seconds, err := strconv.Atoi(maxAge); err == nil {
+			return now.Sub(e.stored) < time.Duration(seconds)*time.Second
+		}
+	}
+	return false
+}
+
+// validators sets the If-None-Match and If-Modified-Since headers within the given request headers, if the entry has
+// an ETag and a Last-Modified header respectively. Headers that have been set by the user will not be overridden.
+func (e *cacheEntry) validators(header http.Header) {
+	if etag := e.response.header.Get("ETag"); etag != "" && header.Get("If-None-Match") == "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := e.response.header.Get("Last-Modified"); lastModified != "" && header.Get("If-Modified-Since") == "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// cacheControl parses the Cache-Control header into a map of directives to their values.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		if directive = strings.TrimSpace(directive); directive != "" {
+			name, value := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, value = directive[:i], strings.Trim(directive[i+1:], "\"")
+			}
+			directives[strings.ToLower(name)] = value
+		}
+	}
+	return directives
+}
+
+// Cache is a HTTP cache of responses keyed by method, URL, and the request headers named by each response's Vary header.
+// Cached responses are revalidated using conditional requests built from their ETag and Last-Modified headers. A Cache
+// can be shared between goroutines.
+type Cache struct {
+	mutex   sync.Mutex
+	entries map[string][]*cacheEntry
+}
+
+// NewCache creates an empty Cache.
+func NewCache() *Cache {
+	return &Cache{entries: make(map[string][]*cacheEntry)}
+}
+
+// Clear removes all the responses stored within the Cache.
+func (c *Cache) Clear() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string][]*cacheEntry)
+}
+
+// Len returns the number of responses stored within the Cache.
+func (c *Cache) Len() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	for _, variants := range c.entries {
+		n += len(variants)
+	}
+	return n
+}
+
+// cacheKey returns the key of the entries within the Cache for the given method and URL. The method is part of the key
+// so that the response to a HEAD request, which has no body, is never served for a GET request, and vice versa.
+func cacheKey(method string, url string) string {
+	return method + " " + url
+}
+
+// lookup finds the entry for the given method and URL that matches the given request headers. A copy of the entry is
+// returned so that it can be read without holding the lock. Returns nil if there is no such entry.
+func (c *Cache) lookup(method string, url string, header http.Header) *cacheEntry {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, entry := range c.entries[cacheKey(method, url)] {
+		if entry.matches(header) {
+			found := *entry
+			return &found
+		}
+	}
+	return nil
+}
+
+// store will store the given response for the given method, URL, and request headers, replacing any entry that
+// matches the same request headers. Responses that are not 200 OK, have neither validators nor a max-age, have a no-store
+// directive, or have a Vary header of "*", will not be stored.
+func (c *Cache) store(method string, url string, header http.Header, resp *response) {
+	directives := cacheControl(resp.header)
+	_, noStore := directives["no-store"]
+	_, maxAge := directives["max-age"]
+	validators := resp.header.Get("ETag") != "" || resp.header.Get("Last-Modified") != ""
+	if resp.code != http.StatusOK || noStore || !(validators || maxAge) {
+		return
+	}
+
+	entry := &cacheEntry{
+		vary:     make(map[string]string),
+		response: resp,
+		stored:   time.Now(),
+	}
+	for _, vary := range resp.header.Values("Vary") {
+		for _, name := range strings.Split(vary, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return
+			} else if name != "" {
+				entry.vary[http.CanonicalHeaderKey(name)] = header.Get(name)
+			}
+		}
+	}
+
+	key := cacheKey(method, url)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	variants := c.entries[key]
+	for i, variant := range variants {
+		if variant.matches(header) {
+			variants[i] = entry
+			return
+		}
+	}
+	c.entries[key] = append(variants, entry)
+}
+
+// revalidated will merge the headers from a 304 Not Modified response into the given entry's response, and store the
+// result in place of the entry for the given method, URL, and request headers. The updated response is returned.
+func (c *Cache) revalidated(method string, url string, header http.Header, entry *cacheEntry, notModified http.Header) *response {
+	updated := *entry.response
+	updated.header = entry.response.header.Clone()
+	for name, values := range notModified {
+		updated.header[name] = values
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, variant := range c.entries[cacheKey(method, url)] {
+		if variant.matches(header) {
+			variant.response = &updated
+			variant.stored = time.Now()
+			break
+		}
+	}
+	return &updated
+}
@@ -0,0 +1,400 @@
+package eval
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/andygello555/data"
+	"github.com/go-resty/resty/v2"
+	"golang.org/x/net/html"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client is shared between all the Method calls made by a single VM. It wraps one resty.Client, so that connections
+// can be reused across calls, and holds any state that should persist between calls, such as the Cache.
+type Client struct {
+	resty     *resty.Client
+	transport *http.Transport
+	// cache stores the responses to GET and HEAD requests so that they can be revalidated using conditional requests.
+	// If this is nil then no responses will be cached. See Client.Cache.
+	cache      *Cache
+	cacheMutex sync.RWMutex
+	// memo memoises the responses to identical requests, and coalesces identical requests that are in flight. If this
+	// is nil then no responses will be memoised. See Client.Memo.
+	memo      *Memo
+	memoMutex sync.RWMutex
+	// resolve maps a "host:port" to the "ip:port" that should be dialled instead. See Client.Resolve.
+	resolve      map[string]string
+	resolveMutex sync.RWMutex
+}
+
+// NewClient creates a new Client with caching disabled and no resolve overrides. The underlying resty.Client has no
+// cookie jar, so the cookies set by one response are never sent with any other call. Cookies are only sent when they
+// are given to a call.
+func NewClient() *Client {
+	c := &Client{
+		cache:   nil,
+		resolve: make(map[string]string),
+	}
+	c.transport = newTransport(c)
+	c.resty = resty.NewWithClient(&http.Client{}).
+		SetTransport(c.transport).
+		SetPreRequestHook(func(_ *resty.Client, req *http.Request) error {
+			// Go ignores the Host and Content-Length headers when sending a request, so we have to set the Host and
+			// ContentLength of the request instead
+			if host := req.Header.Get("Host"); host != "" {
+				req.Host = host
+				req.Header.Del("Host")
+			}
+			if length := req.Header.Get("Content-Length"); length != "" && req.ContentLength == 0 && req.Body != nil {
+				var err error
+				if req.ContentLength, err = strconv.ParseInt(length, 10, 64); err != nil {
+					return err
+				}
+				req.Header.Del("Content-Length")
+			}
+			return nil
+		})
+	return c
+}
+
+// R creates a new resty.Request from the underlying resty.Client, which is made using the given context.Context. The
+// remote address of the connection that the request is sent on is recorded so that it can be read by newResponse.
+func (c *Client) R(ctx context.Context) *resty.Request {
+	addr := &remoteAddr{}
+	ctx = context.WithValue(ctx, remoteAddrKey{}, addr)
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{GotConn: addr.gotConn})
+	return c.resty.R().SetContext(ctx)
+}
+
+// remoteAddrKey is the key of the remoteAddr within the context.Context of a request created by Client.R.
+type remoteAddrKey struct{}
+
+// remoteAddr records the remote address of the last connection that a request was sent on. It is used instead of
+// resty's tracing, which records the timings of a dial from the dialling goroutine.
+type remoteAddr struct {
+	addr  string
+	mutex sync.Mutex
+}
+
+func (r *remoteAddr) gotConn(info httptrace.GotConnInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.addr = info.Conn.RemoteAddr().String()
+}
+
+func (r *remoteAddr) String() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.addr
+}
+
+// Cache returns the Cache of the Client, or nil if caching is disabled.
+func (c *Client) Cache() *Cache {
+	c.cacheMutex.RLock()
+	defer c.cacheMutex.RUnlock()
+	return c.cache
+}
+
+// EnableCache enables or disables the Cache of the Client. Enabling an already enabled Cache will keep the responses
+// that are currently cached. This can be called whilst requests are being made.
+func (c *Client) EnableCache(enable bool) {
+	c.cacheMutex.Lock()
+	defer c.cacheMutex.Unlock()
+	if !enable {
+		c.cache = nil
+	} else if c.cache == nil {
+		c.cache = NewCache()
+	}
+}
+
+// Memo returns the Memo of the Client, or nil if memoisation is disabled.
+func (c *Client) Memo() *Memo {
+	c.memoMutex.RLock()
+	defer c.memoMutex.RUnlock()
+	return c.memo
+}
+
+// SetMemo sets the Memo of the Client. Setting it to nil disables memoisation. This can be called whilst requests are
+// being made.
+func (c *Client) SetMemo(memo *Memo) {
+	c.memoMutex.Lock()
+	defer c.memoMutex.Unlock()
+	c.memo = memo
+}
+
+// Resolve overrides the address that is dialled for the given "host:port" with the given "ip:port", similar to curl's
+// --resolve flag. The URL, Host header, and TLS server name of requests are left untouched. If addr is empty then any
+// override for the "host:port" is removed.
+func (c *Client) Resolve(hostPort string, addr string) error {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return err
+	}
+	hostPort = net.JoinHostPort(strings.ToLower(host), port)
+	if addr != "" {
+		if _, _, err = net.SplitHostPort(addr); err != nil {
+			return err
+		}
+	}
+
+	c.resolveMutex.Lock()
+	if addr == "" {
+		delete(c.resolve, hostPort)
+	} else {
+		c.resolve[hostPort] = addr
+	}
+	c.resolveMutex.Unlock()
+
+	// Idle connections might have been dialled to a previous address for the "host:port"
+	c.transport.CloseIdleConnections()
+	return nil
+}
+
+// ParseResolve parses overrides in the format "host:port=ip:port", separated by commas, and adds each to the Client
+// using Resolve. This is the format of the ResolveEnv environment variable.
+func (c *Client) ParseResolve(s string) (err error) {
+	for _, override := range strings.Split(s, ",") {
+		if override = strings.TrimSpace(override); override == "" {
+			continue
+		}
+		i := strings.Index(override, "=")
+		if i < 0 {
+			return fmt.Errorf("resolve override \"%s\" is not in the format \"host:port=ip:port\"", override)
+		}
+		if err = c.Resolve(strings.TrimSpace(override[:i]), strings.TrimSpace(override[i+1:])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Resolves returns a copy of the resolve overrides of the Client.
+func (c *Client) Resolves() map[string]string {
+	c.resolveMutex.RLock()
+	defer c.resolveMutex.RUnlock()
+	resolves := make(map[string]string, len(c.resolve))
+	for hostPort, addr := range c.resolve {
+		resolves[hostPort] = addr
+	}
+	return resolves
+}
+
+// resolved returns the address that should be dialled for the given "host:port".
+func (c *Client) resolved(hostPort string) string {
+	c.resolveMutex.RLock()
+	defer c.resolveMutex.RUnlock()
+	if host, port, err := net.SplitHostPort(hostPort); err == nil {
+		if addr, ok := c.resolve[net.JoinHostPort(strings.ToLower(host), port)]; ok {
+			return addr
+		}
+	}
+	return hostPort
+}
+
+// output is the metadata of a response body that was streamed to a file, rather than being read into memory.
+type output struct {
+	path   string
+	size   int64
+	sha256 string
+}
+
+// response is a snapshot of a resty.Response which can be stored in the Cache and converted to a data.Value as many
+// times as needed.
+type response struct {
+	status   string
+	code     int
+	header   http.Header
+	body     []byte
+	received time.Time
+	time     time.Duration
+	// output is set when the body of the response was streamed to a file. In which case, body will be empty.
+	output *output
+	// remoteAddr is the address of the connection that the response was received on. This is empty if it is unknown.
+	remoteAddr string
+}
+
+// newResponse takes a snapshot of the given resty.Response.
+func newResponse(resp *resty.Response) *response {
+	r := &response{
+		status:   resp.Status(),
+		code:     resp.StatusCode(),
+		header:   resp.Header().Clone(),
+		body:     resp.Body(),
+		received: resp.ReceivedAt(),
+		time:     resp.Time(),
+	}
+	if addr, ok := resp.Request.Context().Value(remoteAddrKey{}).(*remoteAddr); ok {
+		r.remoteAddr = addr.String()
+	}
+	return r
+}
+
+// save streams the given body to a file at the given path, creating or truncating the file, and stores its metadata
+// within the response. The body is closed afterwards.
+func (r *response) save(body io.ReadCloser, path string) (err error) {
+	defer body.Close()
+	var file *os.File
+	if file, err = os.Create(path); err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	r.output = &output{path: path}
+	if r.output.size, err = io.Copy(io.MultiWriter(file, hash), body); err != nil {
+		return err
+	}
+	r.output.sha256 = hex.EncodeToString(hash.Sum(nil))
+	return file.Close()
+}
+
+// cookies parses the Set-Cookie headers within the response.
+func (r *response) cookies() []*http.Cookie {
+	return (&http.Response{Header: r.header}).Cookies()
+}
+
+// Value constructs the sttp Object that is returned from a Method call. fromCache and revalidated are set within the
+// Object to indicate whether the response was served from the Cache, and whether the server had to be asked if the
+// cached response was still valid. If the body of the response was streamed to a file, then the content will be null
+// and the metadata of the file will be set within the "output" Object.
+func (r *response) Value(fromCache bool, revalidated bool) (err error, value *data.Value) {
+	var content, out, remoteAddr interface{}
+	size := float64(len(r.body))
+	if r.remoteAddr != "" {
+		remoteAddr = r.remoteAddr
+	}
+	if r.output != nil {
+		size = float64(r.output.size)
+		out = map[string]interface{}{
+			"path":         r.output.path,
+			"size":         size,
+			"sha256":       r.output.sha256,
+			"content_type": r.header.Get("Content-Type"),
+		}
+	} else if err, content = r.content(); err != nil {
+		return err, nil
+	}
+
+	return nil, &data.Value{
+		Value: map[string]interface{}{
+			"content":     content,
+			"cookies":     r.cookiesValue(),
+			"headers":     r.headersValue(),
+			"received":    r.received.String(),
+			"size":        size,
+			"status":      r.status,
+			"code":        float64(r.code),
+			"time":        r.time.String(),
+			"from_cache":  fromCache,
+			"revalidated": revalidated,
+			"output":      out,
+			"remote_addr": remoteAddr,
+		},
+		Type:     data.Object,
+		Global:   false,
+		ReadOnly: false,
+	}
+}
+
+// content parses the body of the response. JSON bodies are unmarshalled, HTML bodies are converted to an Object of
+// their parse tree, and any other body is returned as a String.
+func (r *response) content() (err error, content interface{}) {
+	var body *data.Value
+	if err, body = data.ConstructSymbol(string(r.body), false); err != nil {
+		return err, nil
+	}
+
+	if strings.Contains(r.header.Get("content-type"), "text/html") && body.Type == data.String {
+		var root *html.Node
+		if root, err = html.Parse(strings.NewReader(body.StringLit())); err != nil {
+			return err, nil
+		}
+
+		var construct func(curr *html.Node) map[string]interface{}
+		construct = func(curr *html.Node) map[string]interface{} {
+			if !(curr.Type == html.TextNode && strings.TrimSpace(curr.Data) == "") {
+				nodeMap := map[string]interface{}{
+					"type": func() string {
+						switch curr.Type {
+						case html.TextNode:
+							return "text"
+						case html.DocumentNode:
+							return "document"
+						case html.ElementNode:
+							return "element"
+						case html.CommentNode:
+							return "comment"
+						case html.DoctypeNode:
+							return "doctype"
+						default:
+							return "error"
+						}
+					}(),
+					"data": curr.Data,
+					"attributes": func() map[string]interface{} {
+						out := make(map[string]interface{})
+						for _, attr := range curr.Attr {
+							out[attr.Key] = attr.Val
+						}
+						return out
+					}(),
+				}
+
+				// We recurse down each child
+				children := make([]interface{}, 0)
+				for c := curr.FirstChild; c != nil; c = c.NextSibling {
+					childrenMap := construct(c)
+					if childrenMap != nil {
+						children = append(children, childrenMap)
+					}
+				}
+
+				nodeMap["children"] = children
+				return nodeMap
+			}
+			return nil
+		}
+
+		// We construct a value of Object type from the HTML parse tree.
+		body.Value = construct(root)
+		body.Type = data.Object
+	}
+
+	return nil, body.Value
+}
+
+// cookiesValue constructs the Array of cookie Objects for the response.
+func (r *response) cookiesValue() []interface{} {
+	cookies := make([]interface{}, len(r.cookies()))
+	for i, cookie := range r.cookies() {
+		cookies[i] = map[string]interface{}{
+			"name":      cookie.Name,
+			"value":     cookie.Value,
+			"max_age":   float64(cookie.MaxAge),
+			"secure":    cookie.Secure,
+			"http_only": cookie.HttpOnly,
+			"same_site": float64(cookie.SameSite),
+			"raw":       cookie.Raw,
+		}
+	}
+	return cookies
+}
+
+// headersValue constructs the Object of headers for the response.
+func (r *response) headersValue() map[string]interface{} {
+	headers := make(map[string]interface{})
+	for k, v := range r.header {
+		headers[k] = v
+	}
+	return headers
+}
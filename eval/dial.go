@@ -0,0 +1,113 @@
+package eval
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"github.com/andygello555/errors"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	// UnixScheme is the scheme of URLs that target a HTTP server listening on a Unix domain socket. The path to the
+	// socket is followed by a colon and then the path of the request. E.g. "unix:///var/run/app.sock:/v1/status".
+	UnixScheme = "unix"
+	// socketHostSuffix is appended to the hex encoded path of a Unix domain socket to construct the host of a request
+	// to that socket. This keeps the connections to each socket apart from each other, and from TCP connections, within
+	// the http.Transport's connection pool.
+	socketHostSuffix = ".socket.sttp"
+	// socketDefaultHost is the Host header that is used for requests to a Unix domain socket that are given using the
+	// UnixScheme.
+	socketDefaultHost = "localhost"
+)
+
+// socketHost returns the host that is used for requests to the Unix domain socket at the given path.
+func socketHost(socket string) string {
+	return hex.EncodeToString([]byte(socket)) + socketHostSuffix
+}
+
+// hostSocket returns the path of the Unix domain socket that the given host was constructed from using socketHost.
+// Returns false if the host was not constructed using socketHost.
+func hostSocket(host string) (socket string, ok bool) {
+	if !strings.HasSuffix(host, socketHostSuffix) {
+		return "", false
+	}
+	if path, err := hex.DecodeString(strings.TrimSuffix(host, socketHostSuffix)); err == nil {
+		return string(path), true
+	}
+	return "", false
+}
+
+// socketURL rewrites the given URL so that the request is made to the given Unix domain socket. If the socket is an
+// empty string, then the URL must use the UnixScheme. Otherwise, the URL is returned as is. The returned host is the
+// value that should be used for the Host header of the request. This will be empty if the URL was not rewritten.
+// Requests to Unix domain sockets are never made using TLS, so an errors.InvalidMethodOption is returned if the socket
+// is given along with a URL that does not use the http scheme, rather than silently downgrading it.
+func socketURL(rawURL string, socket string) (target string, host string, err error) {
+	if strings.HasPrefix(rawURL, UnixScheme+"://") {
+		// The path of the request starts at the first colon after the path to the socket
+		rest := strings.TrimPrefix(rawURL, UnixScheme+"://")
+		path := "/"
+		if i := strings.Index(rest, ":"); i >= 0 {
+			rest, path = rest[:i], rest[i+1:]
+		}
+		if socket == "" {
+			socket = rest
+		}
+		return "http://" + socketHost(socket) + path, socketDefaultHost, nil
+	} else if socket == "" {
+		return rawURL, "", nil
+	}
+
+	var u *url.URL
+	if u, err = url.Parse(rawURL); err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "http" {
+		return "", "", errors.InvalidMethodOption.Errorf(errors.GetNullVM(), "socket", fmt.Sprintf("cannot be given along with a %s URL", u.Scheme))
+	}
+	host = u.Host
+	u.Host = socketHost(socket)
+	return u.String(), host, nil
+}
+
+// ResolveEnv is the environment variable that VMs read resolve overrides from. See Client.ParseResolve for the format.
+const ResolveEnv = "STTP_RESOLVE"
+
+// newTransport creates the http.Transport that is shared by all the requests made by the given Client. It has the same
+// settings as the default transport used by resty, but its dialer will dial Unix domain sockets for requests to hosts
+// constructed by socketHost, and will dial the address that the Client resolves each "host:port" to.
+func newTransport(c *Client) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			// Requests to Unix domain sockets are never proxied
+			if _, ok := hostSocket(req.URL.Hostname()); ok {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if host, _, err := net.SplitHostPort(addr); err == nil {
+				if socket, ok := hostSocket(host); ok {
+					return dialer.DialContext(ctx, "unix", socket)
+				}
+			}
+			return dialer.DialContext(ctx, network, c.resolved(addr))
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		MaxIdleConnsPerHost:   runtime.GOMAXPROCS(0) + 1,
+	}
+}
@@ -1,12 +1,24 @@
 package eval
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/andygello555/data"
 	"github.com/andygello555/errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -520,6 +532,218 @@ func TestCompute(t *testing.T) {
 	}
 }
 
+func TestComputeUnary(t *testing.T) {
+	for testNo, test := range []struct {
+		op       *data.Value
+		operator UnaryOperator
+		result   *data.Value
+		err      error
+	}{
+		// Unsupported operation
+		{
+			op: &data.Value{
+				Value: nil,
+				Type:  data.Function,
+			},
+			operator: Neg,
+			err:      errors.InvalidUnaryOperation.Errorf(errors.GetNullVM(), "-", "function"),
+		},
+
+		// Logical not uses the same truthiness as casting to a Boolean
+		{
+			op: &data.Value{
+				Value: true,
+				Type:  data.Boolean,
+			},
+			operator: Not,
+			result: &data.Value{
+				Value: false,
+				Type:  data.Boolean,
+			},
+		},
+		{
+			op: &data.Value{
+				Value: float64(-1),
+				Type:  data.Number,
+			},
+			operator: Not,
+			result: &data.Value{
+				Value: true,
+				Type:  data.Boolean,
+			},
+		},
+		{
+			op: &data.Value{
+				Value: "",
+				Type:  data.String,
+			},
+			operator: Not,
+			result: &data.Value{
+				Value: true,
+				Type:  data.Boolean,
+			},
+		},
+		{
+			op: &data.Value{
+				Value: []interface{}{float64(1)},
+				Type:  data.Array,
+			},
+			operator: Not,
+			result: &data.Value{
+				Value: false,
+				Type:  data.Boolean,
+			},
+		},
+		{
+			op: &data.Value{
+				Value: nil,
+				Type:  data.Null,
+			},
+			operator: Not,
+			result: &data.Value{
+				Value: true,
+				Type:  data.Boolean,
+			},
+		},
+
+		// Negation and unary plus cast to a Number
+		{
+			op: &data.Value{
+				Value: float64(5),
+				Type:  data.Number,
+			},
+			operator: Neg,
+			result: &data.Value{
+				Value: float64(-5),
+				Type:  data.Number,
+			},
+		},
+		{
+			op: &data.Value{
+				Value: "12",
+				Type:  data.String,
+			},
+			operator: Neg,
+			result: &data.Value{
+				Value: float64(-12),
+				Type:  data.Number,
+			},
+		},
+		{
+			op: &data.Value{
+				Value: nil,
+				Type:  data.Null,
+			},
+			operator: Neg,
+			result: &data.Value{
+				Value: float64(0),
+				Type:  data.Number,
+			},
+		},
+		{
+			op: &data.Value{
+				Value: true,
+				Type:  data.Boolean,
+			},
+			operator: Pos,
+			result: &data.Value{
+				Value: float64(1),
+				Type:  data.Number,
+			},
+		},
+		{
+			op: &data.Value{
+				Value: "3.5",
+				Type:  data.String,
+			},
+			operator: Pos,
+			result: &data.Value{
+				Value: 3.5,
+				Type:  data.Number,
+			},
+		},
+	} {
+		var ok bool
+		err, result := ComputeUnary(test.operator, test.op)
+		// Check if the actual result is Equal to the expected result only if there is no error.
+		if err == nil {
+			err, ok = Equal(result, test.result)
+		}
+
+		if testing.Verbose() && result != nil {
+			fmt.Printf("%d: %s%v = %v\n", testNo+1, test.operator.String(), test.op.String(), result.String())
+		}
+
+		if test.err != nil {
+			if err == nil || err.Error() != test.err.Error() {
+				t.Errorf("error \"%v\" for testNo: %d does not match the required error: \"%s\"", err, testNo+1, test.err.Error())
+			}
+		} else if err != nil {
+			t.Errorf("error \"%s\" should not have occurred (testNo: %d)", err.Error(), testNo+1)
+		} else if !ok {
+			t.Errorf("result \"%v\" for testNo: %d does not match the required result: \"%v\"", result, testNo+1, test.result)
+		}
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	for testNo, test := range []struct {
+		left     *data.Value
+		operator Operator
+		result   *data.Value
+		ok       bool
+	}{
+		// Only a falsy left operand decides And
+		{
+			left:     &data.Value{Value: false, Type: data.Boolean},
+			operator: And,
+			result:   &data.Value{Value: false, Type: data.Boolean},
+			ok:       true,
+		},
+		{
+			left:     &data.Value{Value: nil, Type: data.Null},
+			operator: And,
+			result:   &data.Value{Value: false, Type: data.Boolean},
+			ok:       true,
+		},
+		{
+			left:     &data.Value{Value: float64(1), Type: data.Number},
+			operator: And,
+		},
+
+		// Only a truthy left operand decides Or
+		{
+			left:     &data.Value{Value: "a", Type: data.String},
+			operator: Or,
+			result:   &data.Value{Value: true, Type: data.Boolean},
+			ok:       true,
+		},
+		{
+			left:     &data.Value{Value: []interface{}{}, Type: data.Array},
+			operator: Or,
+		},
+
+		// Other operators, and operands that cannot be used with And and Or, are never short-circuited
+		{
+			left:     &data.Value{Value: false, Type: data.Boolean},
+			operator: Mul,
+		},
+		{
+			left:     &data.Value{Value: nil, Type: data.Function},
+			operator: Or,
+		},
+	} {
+		result, ok := ShortCircuit(test.operator, test.left)
+		if ok != test.ok {
+			t.Errorf("short-circuit %t for testNo: %d does not match the required %t", ok, testNo+1, test.ok)
+		} else if ok {
+			if _, equal := Equal(result, test.result); !equal {
+				t.Errorf("result \"%v\" for testNo: %d does not match the required result: \"%v\"", result, testNo+1, test.result)
+			}
+		}
+	}
+}
+
 func TestCast(t *testing.T) {
 	for testNo, test := range []struct {
 		from   *data.Value
@@ -1282,7 +1506,7 @@ func TestMethod_Call(t *testing.T) {
 	} {
 		var ok bool
 		var j interface{}
-		err, result := test.method.Call(test.args...)
+		err, result := test.method.Call(nil, test.args...)
 		// Check if the actual result is Equal to the expected result only if there is no error.
 		if err == nil {
 			if err = json.Unmarshal(test.result, &j); err != nil {
@@ -1324,3 +1548,490 @@ func TestMethod_Call(t *testing.T) {
 	}
 	_ = echoChamber.Wait()
 }
+
+func TestMethod_Call_Cache(t *testing.T) {
+	// The server will respond with a different ETag and body depending on the "Accept-Language" header, and will count
+	// the number of full responses that it has sent.
+	fullResponses := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := r.Header.Get("Accept-Language")
+		etag := fmt.Sprintf("\"%s-v1\"", lang)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Vary", "Accept-Language")
+		if r.URL.Path == "/fresh" {
+			w.Header().Set("Cache-Control", "max-age=60")
+		}
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		fullResponses++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, "{\"lang\": \"%s\"}", lang)
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.EnableCache(true)
+	for testNo, test := range []struct {
+		method        Method
+		path          string
+		lang          string
+		fromCache     bool
+		revalidated   bool
+		fullResponses int
+	}{
+		{GET, "/", "en", false, false, 1},
+		{GET, "/", "en", true, true, 1},
+		{GET, "/", "fr", false, false, 2},
+		{GET, "/", "fr", true, true, 2},
+		{GET, "/", "en", true, true, 2},
+		{GET, "/fresh", "en", false, false, 3},
+		{GET, "/fresh", "en", true, false, 3},
+		// HEAD requests are cached separately from GET requests
+		{HEAD, "/fresh", "en", false, false, 4},
+		{HEAD, "/fresh", "en", true, false, 4},
+		{GET, "/fresh", "en", true, false, 4},
+	} {
+		err, result := test.method.Call(client, &data.Value{
+			Value: server.URL + test.path,
+			Type:  data.String,
+		}, &data.Value{
+			Value: map[string]interface{}{"Accept-Language": test.lang},
+			Type:  data.Object,
+		})
+		if err != nil {
+			t.Errorf("error \"%s\" should not have occurred (testNo: %d)", err.Error(), testNo+1)
+			continue
+		}
+
+		response := result.Map()
+		if response["from_cache"] != test.fromCache || response["revalidated"] != test.revalidated {
+			t.Errorf("testNo: %d, from_cache = %v and revalidated = %v, expected %v and %v", testNo+1, response["from_cache"], response["revalidated"], test.fromCache, test.revalidated)
+		}
+		if response["code"] != float64(http.StatusOK) {
+			t.Errorf("testNo: %d, code = %v, expected %d", testNo+1, response["code"], http.StatusOK)
+		}
+		if content, ok := response["content"].(map[string]interface{}); test.method != HEAD && (!ok || content["lang"] != test.lang) {
+			t.Errorf("testNo: %d, content = %v, expected the content for \"%s\"", testNo+1, response["content"], test.lang)
+		}
+		if fullResponses != test.fullResponses {
+			t.Errorf("testNo: %d, server sent %d full responses, expected %d", testNo+1, fullResponses, test.fullResponses)
+		}
+	}
+
+	client.Cache().Clear()
+	if client.Cache().Len() != 0 {
+		t.Errorf("cache has %d entries after being cleared", client.Cache().Len())
+	}
+}
+
+func TestMethod_Call_Memo(t *testing.T) {
+	// The server counts the number of requests that it has received, and blocks each one until release is closed so
+	// that identical requests are in flight at the same time.
+	var requests int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, "{\"path\": \"%s\"}", r.URL.Path)
+	}))
+	defer server.Close()
+
+	client := NewClient()
+	client.SetMemo(NewMemo(0))
+	method := GET
+	call := func(path string, header string) (err error, result *data.Value) {
+		return method.Call(client, &data.Value{
+			Value: server.URL + path,
+			Type:  data.String,
+		}, &data.Value{
+			Value: map[string]interface{}{"X-Test": header},
+			Type:  data.Object,
+		})
+	}
+
+	// Identical requests that are in flight at the same time are coalesced into one
+	const coalesced = 5
+	var wg sync.WaitGroup
+	fromCache := make([]bool, coalesced)
+	for i := 0; i < coalesced; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err, result := call("/a", "1"); err != nil {
+				t.Errorf("error \"%s\" should not have occurred (call: %d)", err.Error(), i+1)
+			} else {
+				fromCache[i] = result.Map()["from_cache"].(bool)
+			}
+		}(i)
+	}
+	// Wait for the first request to reach the server, and the rest to start waiting for it, before releasing it
+	for atomic.LoadInt64(&requests) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	hits := 0
+	for _, hit := range fromCache {
+		if hit {
+			hits++
+		}
+	}
+	if requests != 1 || hits != coalesced-1 {
+		t.Errorf("%d identical requests made %d requests with %d memo hits, expected 1 request with %d hits", coalesced, requests, hits, coalesced-1)
+	}
+
+	for testNo, test := range []struct {
+		path      string
+		header    string
+		fromCache bool
+		requests  int64
+	}{
+		{"/a", "1", true, 1},
+		{"/a", "2", false, 2},
+		{"/b", "1", false, 3},
+		{"/b", "1", true, 3},
+	} {
+		err, result := call(test.path, test.header)
+		if err != nil {
+			t.Errorf("error \"%s\" should not have occurred (testNo: %d)", err.Error(), testNo+1)
+			continue
+		}
+		if result.Map()["from_cache"] != test.fromCache || atomic.LoadInt64(&requests) != test.requests {
+			t.Errorf("testNo: %d, from_cache = %v after %d requests, expected %v after %d requests", testNo+1, result.Map()["from_cache"], requests, test.fromCache, test.requests)
+		}
+	}
+
+	// Once the time-to-live has elapsed the request is made again
+	client.SetMemo(NewMemo(time.Millisecond))
+	_, _ = call("/a", "1")
+	time.Sleep(5 * time.Millisecond)
+	if _, result := call("/a", "1"); result.Map()["from_cache"] != false || atomic.LoadInt64(&requests) != 5 {
+		t.Errorf("expired response was served from the memo, or no request was made (%d requests)", requests)
+	}
+
+	client.Memo().Clear()
+	if client.Memo().Len() != 0 {
+		t.Errorf("memo has %d entries after being cleared", client.Memo().Len())
+	}
+
+	// A call that is waiting for an identical call makes the request itself if the identical call is cancelled. The
+	// first request blocks until it is cancelled.
+	var attempts int64
+	cancelled := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprint(w, "{}")
+	}))
+	defer cancelled.Close()
+
+	url := &data.Value{Value: cancelled.URL, Type: data.String}
+	ctx, cancel := context.WithCancel(context.Background())
+	claimerErr := make(chan error)
+	go func() {
+		err, _ := method.CallContext(ctx, client, url)
+		claimerErr <- err
+	}()
+	for atomic.LoadInt64(&attempts) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	waiter := make(chan *data.Value)
+	go func() {
+		err, result := method.Call(client, url)
+		if err != nil {
+			t.Errorf("error \"%s\" should not have occurred for the waiting call", err.Error())
+		}
+		waiter <- result
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-claimerErr; err == nil {
+		t.Errorf("cancelled call should have failed")
+	}
+	if result := <-waiter; result != nil && result.Map()["from_cache"] != false || atomic.LoadInt64(&attempts) != 2 {
+		t.Errorf("waiting call was not made again after the identical call was cancelled (%d requests)", attempts)
+	}
+
+	// Unsafe methods are never memoised, so identical POST requests are all made
+	posted := atomic.LoadInt64(&attempts)
+	post := POST
+	for i := 0; i < 2; i++ {
+		if err, result := post.Call(client, url); err != nil {
+			t.Errorf("error \"%s\" should not have occurred (post: %d)", err.Error(), i+1)
+		} else if result.Map()["from_cache"] != false {
+			t.Errorf("post: %d, was served from the memo", i+1)
+		}
+	}
+	if atomic.LoadInt64(&attempts) != posted+2 {
+		t.Errorf("2 identical POST requests made %d requests, expected 2", atomic.LoadInt64(&attempts)-posted)
+	}
+
+	// Requests to different Unix domain sockets are memoised separately
+	request := client.R(context.Background())
+	_, first := memoKey(GET, "http://localhost/", "/a.sock", request)
+	_, second := memoKey(GET, "http://localhost/", "/b.sock", request)
+	if first == second {
+		t.Errorf("requests to different sockets have the same memo key: %s", first)
+	}
+}
+
+func TestMethod_Call_Socket(t *testing.T) {
+	// The server listens on a Unix domain socket in a temporary directory, and echoes the host and path of each request.
+	dir, err := os.MkdirTemp("", "sttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "app.sock")
+	var listener net.Listener
+	if listener, err = net.Listen("unix", socket); err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, "{\"host\": \"%s\", \"path\": \"%s\"}", r.Host, r.URL.RequestURI())
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	client := NewClient()
+	method := GET
+	for testNo, test := range []struct {
+		args []*data.Value
+		host string
+		path string
+		err  error
+	}{
+		{
+			args: []*data.Value{{Value: "unix://" + socket + ":/v1/status?verbose=true", Type: data.String}},
+			host: "localhost",
+			path: "/v1/status?verbose=true",
+		},
+		{
+			args: []*data.Value{{Value: "unix://" + socket, Type: data.String}},
+			host: "localhost",
+			path: "/",
+		},
+		{
+			args: []*data.Value{
+				{Value: "http://api.example.com/v1/status", Type: data.String},
+				{Value: nil, Type: data.Null},
+				{Value: nil, Type: data.Null},
+				{Value: map[string]interface{}{"socket": socket}, Type: data.Object},
+			},
+			host: "api.example.com",
+			path: "/v1/status",
+		},
+		{
+			args: []*data.Value{
+				{Value: "unix://" + socket + ":/v1/status", Type: data.String},
+				{Value: map[string]interface{}{"Host": "docker"}, Type: data.Object},
+			},
+			host: "docker",
+			path: "/v1/status",
+		},
+		{
+			args: []*data.Value{
+				{Value: "http://api.example.com/v1/status", Type: data.String},
+				{Value: nil, Type: data.Null},
+				{Value: nil, Type: data.Null},
+				{Value: map[string]interface{}{"sock": socket}, Type: data.Object},
+			},
+			err: errors.InvalidMethodOption.Errorf(errors.GetNullVM(), "sock", "unknown option"),
+		},
+		{
+			args: []*data.Value{
+				{Value: "https://api.example.com/v1/status", Type: data.String},
+				{Value: nil, Type: data.Null},
+				{Value: nil, Type: data.Null},
+				{Value: map[string]interface{}{"socket": socket}, Type: data.Object},
+			},
+			err: errors.InvalidMethodOption.Errorf(errors.GetNullVM(), "socket", "cannot be given along with a https URL"),
+		},
+	} {
+		err, result := method.Call(client, test.args...)
+		if test.err != nil {
+			if err == nil || err.Error() != test.err.Error() {
+				t.Errorf("error \"%v\" for testNo: %d does not match the required error: \"%s\"", err, testNo+1, test.err.Error())
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("error \"%s\" should not have occurred (testNo: %d)", err.Error(), testNo+1)
+			continue
+		}
+
+		content, ok := result.Map()["content"].(map[string]interface{})
+		if !ok || content["host"] != test.host || content["path"] != test.path {
+			t.Errorf("testNo: %d, content = %v, expected host \"%s\" and path \"%s\"", testNo+1, result.Map()["content"], test.host, test.path)
+		}
+	}
+}
+
+func TestMethod_Call_Stream(t *testing.T) {
+	// The server will respond to "/download" with a large body, and to "/upload" with the length and SHA-256 of the
+	// request's body.
+	payload := strings.Repeat("sttp streams large bodies\n", 1<<16)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/download":
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = io.WriteString(w, payload)
+		case "/upload":
+			hash := sha256.New()
+			n, _ := io.Copy(hash, r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, "{\"size\": %d, \"content_length\": %d, \"sha256\": \"%x\"}", n, r.ContentLength, hash.Sum(nil))
+		}
+	}))
+	defer server.Close()
+
+	dir, err := os.MkdirTemp("", "sttp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sum := sha256.Sum256([]byte(payload))
+	expectedSum := hex.EncodeToString(sum[:])
+
+	// Download the payload to a file
+	client := NewClient()
+	output := filepath.Join(dir, "download.txt")
+	method := GET
+	err, result := method.Call(client,
+		&data.Value{Value: server.URL + "/download", Type: data.String},
+		&data.Value{Value: nil, Type: data.Null},
+		&data.Value{Value: nil, Type: data.Null},
+		&data.Value{Value: map[string]interface{}{"output": output}, Type: data.Object},
+	)
+	if err != nil {
+		t.Fatalf("error \"%s\" should not have occurred whilst downloading", err.Error())
+	}
+
+	response := result.Map()
+	expectedOutput := map[string]interface{}{
+		"path":         output,
+		"size":         float64(len(payload)),
+		"sha256":       expectedSum,
+		"content_type": "text/plain",
+	}
+	if !reflect.DeepEqual(response["output"], expectedOutput) {
+		t.Errorf("output = %v, expected %v", response["output"], expectedOutput)
+	}
+	if response["content"] != nil || response["size"] != float64(len(payload)) {
+		t.Errorf("content = %v and size = %v, expected null and %d", response["content"], response["size"], len(payload))
+	}
+	if written, _ := os.ReadFile(output); string(written) != payload {
+		t.Errorf("file at %s does not contain the payload", output)
+	}
+
+	// Upload the downloaded file
+	method = POST
+	if err, result = method.Call(client,
+		&data.Value{Value: server.URL + "/upload", Type: data.String},
+		&data.Value{Value: nil, Type: data.Null},
+		&data.Value{Value: nil, Type: data.Null},
+		&data.Value{Value: nil, Type: data.Null},
+		&data.Value{Value: map[string]interface{}{"body_file": output}, Type: data.Object},
+	); err != nil {
+		t.Fatalf("error \"%s\" should not have occurred whilst uploading", err.Error())
+	}
+	expectedContent := map[string]interface{}{
+		"size":           float64(len(payload)),
+		"content_length": float64(len(payload)),
+		"sha256":         expectedSum,
+	}
+	if !reflect.DeepEqual(result.Map()["content"], expectedContent) {
+		t.Errorf("content = %v, expected %v", result.Map()["content"], expectedContent)
+	}
+
+	// A body file cannot be given alongside a body
+	if err, _ = method.Call(client,
+		&data.Value{Value: server.URL + "/upload", Type: data.String},
+		&data.Value{Value: "body", Type: data.String},
+		&data.Value{Value: nil, Type: data.Null},
+		&data.Value{Value: nil, Type: data.Null},
+		&data.Value{Value: map[string]interface{}{"body_file": output}, Type: data.Object},
+	); err == nil || err.Error() != errors.InvalidMethodOption.Errorf(errors.GetNullVM(), "body_file", "cannot be given along with a body").Error() {
+		t.Errorf("error \"%v\" does not match the expected InvalidMethodOption error", err)
+	}
+}
+
+func TestClient_Resolve(t *testing.T) {
+	// The server echoes the Host header of each request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, "{\"host\": \"%s\"}", r.Host)
+	}))
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	client := NewClient()
+	if err := client.ParseResolve(fmt.Sprintf("API.example.com:80=%s, other.example.com:443=127.0.0.1:8443", addr)); err != nil {
+		t.Fatalf("error \"%s\" should not have occurred whilst parsing overrides", err.Error())
+	}
+	expectedResolves := map[string]string{
+		"api.example.com:80":    addr,
+		"other.example.com:443": "127.0.0.1:8443",
+	}
+	if !reflect.DeepEqual(client.Resolves(), expectedResolves) {
+		t.Errorf("resolves = %v, expected %v", client.Resolves(), expectedResolves)
+	}
+
+	method := GET
+	err, result := method.Call(client, &data.Value{Value: "http://api.example.com/", Type: data.String})
+	if err != nil {
+		t.Fatalf("error \"%s\" should not have occurred", err.Error())
+	}
+	response := result.Map()
+	if content, ok := response["content"].(map[string]interface{}); !ok || content["host"] != "api.example.com" {
+		t.Errorf("content = %v, expected the host to be \"api.example.com\"", response["content"])
+	}
+	if response["remote_addr"] != addr {
+		t.Errorf("remote_addr = %v, expected %s", response["remote_addr"], addr)
+	}
+
+	// Removing the override
+	if err = client.Resolve("api.example.com:80", ""); err != nil {
+		t.Errorf("error \"%s\" should not have occurred whilst removing an override", err.Error())
+	}
+	if _, ok := client.Resolves()["api.example.com:80"]; ok {
+		t.Errorf("override for api.example.com:80 was not removed")
+	}
+
+	for _, invalid := range []string{"api.example.com=127.0.0.1:80", "api.example.com:80", "api.example.com:80=127.0.0.1"} {
+		if err = client.ParseResolve(invalid); err == nil {
+			t.Errorf("parsing \"%s\" should have errored", invalid)
+		}
+	}
+}
+
+func TestClient_Cookies(t *testing.T) {
+	// The server sets a cookie on every response, and echoes the Cookie header of each request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret", Path: "/"})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, "{\"cookie\": \"%s\"}", r.Header.Get("Cookie"))
+	}))
+	defer server.Close()
+
+	// Cookies set by one call are not sent with later calls made using the same Client
+	client := NewClient()
+	method := GET
+	for testNo := 0; testNo < 2; testNo++ {
+		err, result := method.Call(client, &data.Value{Value: server.URL + "/", Type: data.String})
+		if err != nil {
+			t.Fatalf("error \"%s\" should not have occurred (testNo: %d)", err.Error(), testNo+1)
+		}
+		if content, ok := result.Map()["content"].(map[string]interface{}); !ok || content["cookie"] != "" {
+			t.Errorf("testNo: %d, content = %v, expected no cookies to be sent", testNo+1, result.Map()["content"])
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package eval
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"github.com/go-resty/resty/v2"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoEnv is the environment variable that VMs and TestSuites read the time-to-live of their Memo from. It is parsed
+// using time.ParseDuration, and a duration of 0 means that memoised responses never expire. If it is not set then
+// memoisation is disabled.
+const MemoEnv = "STTP_MEMO_TTL"
+
+// memoEntry is a single memoised Method call. done is closed once the call has finished, after which response, err,
+// and stored will not change.
+type memoEntry struct {
+	done     chan struct{}
+	response *response
+	err      error
+	stored   time.Time
+}
+
+// Memo memoises the responses of Method calls, keyed by the method, URL, headers, cookies, and body of each request.
+// Only the responses of GET, HEAD, and OPTIONS calls are memoised. Unlike the Cache, responses are memoised regardless
+// of their status and headers. Identical calls that are made whilst
+// the first is still in flight wait for, and share, its response rather than making a request of their own. Failed
+// calls are never memoised. A Memo can be shared between goroutines, and between Clients.
+type Memo struct {
+	// TTL is how long responses are memoised for. If this is 0 then responses are memoised forever.
+	TTL     time.Duration
+	mutex   sync.Mutex
+	entries map[string]*memoEntry
+}
+
+// NewMemo creates an empty Memo with the given time-to-live.
+func NewMemo(ttl time.Duration) *Memo {
+	return &Memo{
+		TTL:     ttl,
+		entries: make(map[string]*memoEntry),
+	}
+}
+
+// ParseMemo creates an empty Memo with the time-to-live given in the format of the MemoEnv environment variable.
+func ParseMemo(s string) (err error, memo *Memo) {
+	var ttl time.Duration
+	if ttl, err = time.ParseDuration(s); err != nil {
+		return err, nil
+	}
+	if ttl < 0 {
+		return fmt.Errorf("memo time-to-live \"%s\" cannot be negative", s), nil
+	}
+	return nil, NewMemo(ttl)
+}
+
+// Clear removes all the responses memoised within the Memo. Calls that are in flight will still be shared with any
+// identical calls that are waiting for them.
+func (m *Memo) Clear() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.entries = make(map[string]*memoEntry)
+}
+
+// Len returns the number of responses memoised within the Memo, including those for calls that are in flight.
+func (m *Memo) Len() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return len(m.entries)
+}
+
+// claim finds the entry for the given key. If there is no entry, or the entry has expired, then a new entry is created
+// and claimed is true. The caller must then make the call and complete the entry. Otherwise, the caller should wait for
+// the entry to be done.
+func (m *Memo) claim(key string, now time.Time) (entry *memoEntry, claimed bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if entry = m.entries[key]; entry != nil {
+		select {
+		case <-entry.done:
+			if m.TTL == 0 || now.Sub(entry.stored) < m.TTL {
+				return entry, false
+			}
+		default:
+			// The call is still in flight
+			return entry, false
+		}
+	}
+	entry = &memoEntry{done: make(chan struct{})}
+	m.entries[key] = entry
+	return entry, true
+}
+
+// complete settles the given claimed entry with the given response and error. If the call failed then the entry is
+// removed, so that the next identical call is made again.
+func (m *Memo) complete(key string, entry *memoEntry, resp *response, err error) {
+	m.mutex.Lock()
+	entry.response, entry.err, entry.stored = resp, err, time.Now()
+	if err != nil && m.entries[key] == entry {
+		delete(m.entries, key)
+	}
+	m.mutex.Unlock()
+	close(entry.done)
+}
+
+// contextError checks whether the given error was caused by a context.Context being cancelled, or its deadline being
+// exceeded.
+func contextError(err error) bool {
+	return goerrors.Is(err, context.Canceled) || goerrors.Is(err, context.DeadlineExceeded)
+}
+
+// memoKey constructs the key of the given request within a Memo. The Unix domain socket that the request is made to, if
+// any, is part of the key. The keys of maps are sorted when marshalled to JSON, and cookies are sorted by name, so the
+// key does not depend on the order that they were given in.
+func memoKey(method Method, url string, socket string, request *resty.Request) (err error, key string) {
+	cookies := make([]string, len(request.Cookies))
+	for i, cookie := range request.Cookies {
+		cookies[i] = cookie.Name + "=" + cookie.Value
+	}
+	sort.Strings(cookies)
+
+	var b []byte
+	if b, err = json.Marshal(struct {
+		Method  string
+		URL     string
+		Socket  string
+		Header  http.Header
+		Cookies []string
+		Body    interface{}
+	}{method.String(), url, socket, request.Header, cookies, request.Body}); err != nil {
+		return err, ""
+	}
+	return nil, string(b)
+}
@@ -1,13 +1,15 @@
 package eval
 
 import (
+	"context"
 	"fmt"
 	"github.com/andygello555/data"
 	"github.com/andygello555/errors"
 	"github.com/go-resty/resty/v2"
-	"golang.org/x/net/html"
 	"net/http"
-	"strings"
+	"os"
+	"strconv"
+	"time"
 )
 
 // Method represents a valid HTTP method supported by sttp.
@@ -60,6 +62,9 @@ const (
 	Body
 	Headers
 	Cookies
+	// Options is always the last parameter of a Method. It is an Object of MethodOptions which change how the request
+	// is made.
+	Options
 )
 
 var methodParamTypeName = map[MethodParamType]string{
@@ -67,6 +72,7 @@ var methodParamTypeName = map[MethodParamType]string{
 	Body:    "body",
 	Headers: "headers",
 	Cookies: "cookies",
+	Options: "options",
 }
 
 func (mpt MethodParamType) String() string {
@@ -76,13 +82,56 @@ func (mpt MethodParamType) String() string {
 // methodParams is a lookup of parameters which are required for all the supported Methods. true indicates the argument
 // is required, false indicates that the argument is not required.
 var methodParams = map[Method]map[MethodParamType]bool{
-	GET:     {Url: true, Headers: false, Cookies: false},
-	HEAD:    {Url: true, Headers: false, Cookies: false},
-	POST:    {Url: true, Headers: false, Cookies: false, Body: false},
-	PUT:     {Url: true, Headers: false, Cookies: false, Body: false},
-	DELETE:  {Url: true, Headers: false, Cookies: false, Body: false},
-	OPTIONS: {Url: true, Headers: false, Cookies: false},
-	PATCH:   {Url: true, Headers: false, Cookies: false, Body: false},
+	GET:     {Url: true, Headers: false, Cookies: false, Options: false},
+	HEAD:    {Url: true, Headers: false, Cookies: false, Options: false},
+	POST:    {Url: true, Headers: false, Cookies: false, Body: false, Options: false},
+	PUT:     {Url: true, Headers: false, Cookies: false, Body: false, Options: false},
+	DELETE:  {Url: true, Headers: false, Cookies: false, Body: false, Options: false},
+	OPTIONS: {Url: true, Headers: false, Cookies: false, Options: false},
+	PATCH:   {Url: true, Headers: false, Cookies: false, Body: false, Options: false},
+}
+
+// MethodOptions are the options that can be given in the Options argument of a Method call.
+type MethodOptions struct {
+	// Socket is the path to a Unix domain socket that the request will be made to, instead of the host of the URL.
+	Socket string
+	// Output is the path to a file that the body of the response will be streamed to, instead of being read into
+	// memory.
+	Output string
+	// BodyFile is the path to a file that the body of the request will be streamed from.
+	BodyFile string
+}
+
+// GetOptions parses the given Options argument into MethodOptions. Returns an errors.InvalidMethodOption if an option
+// is unknown or is not of the correct type.
+func GetOptions(arg *data.Value) (err error, options *MethodOptions) {
+	if arg.Type != data.Object {
+		if err, arg = Cast(arg, data.Object); err != nil {
+			return err, nil
+		}
+	}
+
+	options = &MethodOptions{}
+	for k, v := range arg.Map() {
+		switch k {
+		case "socket", "output", "body_file":
+			path, ok := v.(string)
+			if !ok {
+				return errors.InvalidMethodOption.Errorf(errors.GetNullVM(), k, "must be a string"), nil
+			}
+			switch k {
+			case "socket":
+				options.Socket = path
+			case "output":
+				options.Output = path
+			case "body_file":
+				options.BodyFile = path
+			}
+		default:
+			return errors.InvalidMethodOption.Errorf(errors.GetNullVM(), k, "unknown option"), nil
+		}
+	}
+	return nil, options
 }
 
 // ApplyArg will call the relevant setter on the given resty.Request pointer. Will return an error if a Cast went awry.
@@ -163,14 +212,35 @@ func (m *Method) GetParamType(arg int) MethodParamType {
 	return MethodParamType(mpt)
 }
 
-// Call will call the HTTP method.
-func (m *Method) Call(args ...*data.Value) (err error, value *data.Value) {
+// Call will call the HTTP method using the given Client. If the Client is nil then a new Client will be created just
+// for this call. If the Client has a Cache, then GET and HEAD requests will be served from, and stored within, it. If
+// the Client has a Memo, then identical GET, HEAD, and OPTIONS requests will share the same response. Requests to URLs with the UnixScheme,
+// or with the socket option set, will be made to a Unix domain socket. If the output option is set, then the response
+// body is streamed to a file and will be neither cached nor memoised.
+func (m *Method) Call(client *Client, args ...*data.Value) (err error, value *data.Value) {
+	return m.CallContext(context.Background(), client, args...)
+}
+
+// CallContext is the same as Call, but the request is made using the given context.Context. If the context.Context is
+// cancelled then the request is aborted.
+func (m *Method) CallContext(ctx context.Context, client *Client, args ...*data.Value) (err error, value *data.Value) {
 	if len(args) > 0 {
-		request := resty.New().R()
+		if client == nil {
+			client = NewClient()
+		}
+
+		request := client.R(ctx)
+		options := &MethodOptions{}
+		body := false
 		for i, arg := range args {
 			mpt := m.GetParamType(i)
 			if arg.Type != data.Null {
-				if err = mpt.ApplyArg(arg, request); err != nil {
+				body = body || mpt == Body
+				if mpt == Options {
+					if err, options = GetOptions(arg); err != nil {
+						return err, nil
+					}
+				} else if err = mpt.ApplyArg(arg, request); err != nil {
 					return err, nil
 				}
 			} else {
@@ -181,111 +251,128 @@ func (m *Method) Call(args ...*data.Value) (err error, value *data.Value) {
 			}
 		}
 
-		var resp *resty.Response
-		if resp, err = request.Execute(m.String(), args[0].StringLit()); err != nil {
+		// If the request is to a Unix domain socket then the URL will be rewritten so that the Client's transport can
+		// dial the socket. The rewritten URL is also used as the key for the Cache, so that the same URL requested over
+		// TCP and over a socket are cached separately.
+		var url, host string
+		if url, host, err = socketURL(args[0].StringLit(), options.Socket); err != nil {
 			return err, nil
 		}
-
-		var body *data.Value
-		if err, body = data.ConstructSymbol(string(resp.Body()), false); err != nil {
-			return err, nil
+		if host != "" && request.Header.Get("Host") == "" {
+			request.SetHeader("Host", host)
 		}
 
-		if strings.Contains(resp.Header().Get("content-type"), "text/html") && body.Type == data.String {
-			var root *html.Node
-			if root, err = html.Parse(strings.NewReader(body.StringLit())); err != nil {
-				return err, nil
+		if options.BodyFile != "" {
+			if _, ok := methodParams[*m][Body]; !ok {
+				return errors.InvalidMethodOption.Errorf(errors.GetNullVM(), "body_file", fmt.Sprintf("%s requests cannot have a body", m.String())), nil
+			} else if body {
+				return errors.InvalidMethodOption.Errorf(errors.GetNullVM(), "body_file", "cannot be given along with a body"), nil
 			}
 
-			var construct func(curr *html.Node) map[string]interface{}
-			construct = func(curr *html.Node) map[string]interface{} {
-				if !(curr.Type == html.TextNode && strings.TrimSpace(curr.Data) == "") {
-					nodeMap := map[string]interface{}{
-						"type": func() string {
-							switch curr.Type {
-							case html.TextNode:
-								return "text"
-							case html.DocumentNode:
-								return "document"
-							case html.ElementNode:
-								return "element"
-							case html.CommentNode:
-								return "comment"
-							case html.DoctypeNode:
-								return "doctype"
-							default:
-								return "error"
-							}
-						}(),
-						"data": curr.Data,
-						"attributes": func() map[string]interface{} {
-							out := make(map[string]interface{})
-							for _, attr := range curr.Attr {
-								out[attr.Key] = attr.Val
-							}
-							return out
-						}(),
-					}
+			var file *os.File
+			var info os.FileInfo
+			if file, err = os.Open(options.BodyFile); err != nil {
+				return err, nil
+			}
+			defer file.Close()
+			if info, err = file.Stat(); err != nil {
+				return err, nil
+			}
+			// The Content-Length header is used to set the length of the streamed body, rather than sending it chunked
+			request.SetBody(file)
+			request.SetHeader("Content-Length", strconv.FormatInt(info.Size(), 10))
+		}
 
-					// We recurse down each child
-					children := make([]interface{}, 0)
-					for c := curr.FirstChild; c != nil; c = c.NextSibling {
-						childrenMap := construct(c)
-						if childrenMap != nil {
-							children = append(children, childrenMap)
-						}
-					}
+		if options.Output != "" {
+			// We read the body of the response ourselves so that it is never read into memory
+			request.SetDoNotParseResponse(true)
+		}
 
-					nodeMap["children"] = children
-					return nodeMap
-				}
-				return nil
+		var snapshot *response
+		var fromCache, revalidated bool
+		// Only safe methods are memoised, as making the same unsafe request twice should change the server twice
+		memo := client.Memo()
+		safe := *m == GET || *m == HEAD || *m == OPTIONS
+		if memo == nil || !safe || options.Output != "" || options.BodyFile != "" {
+			if err, snapshot, fromCache, revalidated = m.execute(client, request, url, options); err != nil {
+				return err, nil
 			}
+			return snapshot.Value(fromCache, revalidated)
+		}
 
-			// We construct a value of Object type from the HTML parse tree.
-			body.Value = construct(root)
-			body.Type = data.Object
+		// If the Client has a Memo, then only the first of any identical calls will make the request. The rest will
+		// wait for it to finish and share its response.
+		var key string
+		if err, key = memoKey(*m, url, options.Socket, request); err != nil {
+			return err, nil
+		}
+		entry, claimed := memo.claim(key, time.Now())
+		for !claimed {
+			select {
+			case <-entry.done:
+			case <-ctx.Done():
+				return ctx.Err(), nil
+			}
+			if entry.err == nil {
+				return entry.response.Value(true, false)
+			}
+			// If the call failed because its own context.Context was cancelled, such as by a fail fast or timed out
+			// batch, then the failed entry has been removed and the call is made again, or waited for again.
+			if !contextError(entry.err) || ctx.Err() != nil {
+				return entry.err, nil
+			}
+			entry, claimed = memo.claim(key, time.Now())
 		}
 
-		value = &data.Value{
-			Value: map[string]interface{}{
-				"content": body.Value,
-				"cookies": func() (cookies []interface{}) {
-					cookies = make([]interface{}, len(resp.Cookies()))
-					for i, cookie := range resp.Cookies() {
-						cookies[i] = map[string]interface{}{
-							"name":      cookie.Name,
-							"value":     cookie.Value,
-							"max_age":   float64(cookie.MaxAge),
-							"secure":    cookie.Secure,
-							"http_only": cookie.HttpOnly,
-							"same_site": float64(cookie.SameSite),
-							"raw":       cookie.Raw,
-						}
-					}
-					return cookies
-				}(),
-				"headers": func() (headers map[string]interface{}) {
-					headers = make(map[string]interface{})
-					for k, v := range resp.Header() {
-						headers[k] = v
-					}
-					return headers
-				}(),
-				"received": resp.ReceivedAt().String(),
-				"size":     float64(resp.Size()),
-				"status":   resp.Status(),
-				"code":     float64(resp.StatusCode()),
-				"time":     resp.Time().String(),
-			},
-			Type:     data.Object,
-			Global:   false,
-			ReadOnly: false,
+		err, snapshot, fromCache, revalidated = m.execute(client, request, url, options)
+		memo.complete(key, entry, snapshot, err)
+		if err != nil {
+			return err, nil
 		}
+		return snapshot.Value(fromCache, revalidated)
 	}
 	return err, value
 }
 
+// execute makes the given request to the given URL, serving it from, and storing it within, the Cache of the given
+// Client if possible. The snapshot of the response is returned along with whether it was served from the Cache, and
+// whether the Cache had to revalidate it with the server.
+func (m *Method) execute(client *Client, request *resty.Request, url string, options *MethodOptions) (err error, snapshot *response, fromCache bool, revalidated bool) {
+	var cached *cacheEntry
+	cache := client.Cache()
+	cacheable := cache != nil && (*m == GET || *m == HEAD) && options.Output == ""
+	if cacheable {
+		// If we have a fresh response in the cache we can skip the request entirely. Otherwise, we will ask the
+		// server whether our cached response is still valid.
+		if cached = cache.lookup(m.String(), url, request.Header); cached != nil {
+			if cached.fresh(time.Now()) {
+				return nil, cached.response, true, false
+			}
+			cached.validators(request.Header)
+		}
+	}
+
+	var resp *resty.Response
+	if resp, err = request.Execute(m.String(), url); err != nil {
+		return err, nil, false, false
+	}
+
+	if cached != nil && resp.StatusCode() == http.StatusNotModified {
+		return nil, cache.revalidated(m.String(), url, request.Header, cached, resp.Header()), true, true
+	}
+
+	snapshot = newResponse(resp)
+	if options.Output != "" {
+		if err = snapshot.save(resp.RawBody(), options.Output); err != nil {
+			return err, nil, false, false
+		}
+	}
+	if cacheable {
+		cache.store(m.String(), url, request.Header, snapshot)
+	}
+	return nil, snapshot, false, false
+}
+
 // Capture method for participle lexer.
 func (m *Method) Capture(s []string) error {
 	var ok bool
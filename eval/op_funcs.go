@@ -820,3 +820,44 @@ func orBoolean(op1 *data.Value, op2 *data.Value) (err error, result *data.Value)
 func orNull(op1 *data.Value, op2 *data.Value) (err error, result *data.Value) {
 	return boolean(op1, op2, Or)
 }
+
+// uoFunc is a dummy function which exists within the unaryOperatorTable to represent an operation which cannot be made.
+func uoFunc(op *data.Value) (err error, result *data.Value) {
+	return nil, nil
+}
+
+// not: Logical NOT. Casts the operand to a Boolean and negates it.
+func not(op *data.Value) (err error, result *data.Value) {
+	if err, result = Cast(op, data.Boolean); err != nil {
+		return err, nil
+	}
+	return nil, &data.Value{
+		Value:  !result.Value.(bool),
+		Type:   data.Boolean,
+		Global: op.Global,
+	}
+}
+
+// neg: Numeric negation. Casts the operand to a Number and subtracts it from 0, so that negating 0 does not produce -0.
+func neg(op *data.Value) (err error, result *data.Value) {
+	if err, result = Cast(op, data.Number); err != nil {
+		return err, nil
+	}
+	return nil, &data.Value{
+		Value:  0 - result.Value.(float64),
+		Type:   data.Number,
+		Global: op.Global,
+	}
+}
+
+// pos: Numeric identity. Casts the operand to a Number.
+func pos(op *data.Value) (err error, result *data.Value) {
+	if err, result = Cast(op, data.Number); err != nil {
+		return err, nil
+	}
+	return nil, &data.Value{
+		Value:  result.Value.(float64),
+		Type:   data.Number,
+		Global: op.Global,
+	}
+}
@@ -86,21 +86,21 @@ var o = oFunc
 
 // operatorTable is a lookup which contains the functions which carry out operation calls. Each row represents the
 // operator that is being called. Whereas, each column represents the type on the left-hand side of the expression.
-var operatorTable = [13][8]func(op1 *data.Value, op2 *data.Value) (err error, result *data.Value){
-	/*           NoType    Object    Array    String    Number    Boolean    Null    Function                    */
-	/* Mul */ {o, o, o, muString, muNumber, anBoolean, op1, o},
-	/* Div */ {o, diObject, o, o, diNumber, diBoolean, op1, o},
-	/* Mod */ {o, o, o, moString, moNumber, moBoolean, op1, o},
-	/* Add */ {o, adObject, adArray, adString, adNumber, orBoolean, op1, o},
-	/* Sub */ {o, suObject, suArray, suString, suNumber, suBoolean, op1, o},
-	/* Lt  */ {o, ltObject, ltArray, ltString, ltNumber, ltBoolean, o, o},
-	/* Gt  */ {o, gtObject, gtArray, gtString, gtNumber, gtBoolean, o, o},
-	/* Lte */ {o, leObject, leArray, leString, leNumber, leBoolean, o, o},
-	/* Gte */ {o, geObject, geArray, geString, geNumber, geBoolean, o, o},
-	/* Eq  */ {o, eqObject, eqArray, eqString, eqNumber, eqBoolean, eqNull, o},
-	/* Ne  */ {o, neObject, neArray, neString, neNumber, neBoolean, neNull, o},
-	/* And */ {o, anObject, anArray, anString, anNumber, anBoolean, anNull, o},
-	/* Or  */ {o, orObject, orArray, orString, orNumber, orBoolean, orNull, o},
+var operatorTable = [13][10]func(op1 *data.Value, op2 *data.Value) (err error, result *data.Value){
+	/*           NoType    Object    Array    String    Number    Boolean    Null    Function    Iterable    Future    */
+	/* Mul */ {o, o, o, muString, muNumber, anBoolean, op1, o, o, o},
+	/* Div */ {o, diObject, o, o, diNumber, diBoolean, op1, o, o, o},
+	/* Mod */ {o, o, o, moString, moNumber, moBoolean, op1, o, o, o},
+	/* Add */ {o, adObject, adArray, adString, adNumber, orBoolean, op1, o, o, o},
+	/* Sub */ {o, suObject, suArray, suString, suNumber, suBoolean, op1, o, o, o},
+	/* Lt  */ {o, ltObject, ltArray, ltString, ltNumber, ltBoolean, o, o, o, o},
+	/* Gt  */ {o, gtObject, gtArray, gtString, gtNumber, gtBoolean, o, o, o, o},
+	/* Lte */ {o, leObject, leArray, leString, leNumber, leBoolean, o, o, o, o},
+	/* Gte */ {o, geObject, geArray, geString, geNumber, geBoolean, o, o, o, o},
+	/* Eq  */ {o, eqObject, eqArray, eqString, eqNumber, eqBoolean, eqNull, o, o, o},
+	/* Ne  */ {o, neObject, neArray, neString, neNumber, neBoolean, neNull, o, o, o},
+	/* And */ {o, anObject, anArray, anString, anNumber, anBoolean, anNull, o, o, o},
+	/* Or  */ {o, orObject, orArray, orString, orNumber, orBoolean, orNull, o, o, o},
 }
 
 // Compute will compute the result of the given binary operation with the given left and right operands. Internally this
@@ -116,3 +116,100 @@ func Compute(operator Operator, left *data.Value, right *data.Value) (err error,
 	// Otherwise, we return the result of the computation method.
 	return operatorTable[operator][left.Type](left, right)
 }
+
+// ShortCircuit checks whether the result of the given binary operation is decided by the left operand alone. This is
+// the case for And when the left operand is falsy, and for Or when the left operand is truthy. If so, the Boolean
+// result is returned along with true, and the right operand does not need to be evaluated. Left operands that cannot be
+// used with the operator are never short-circuited, so that Compute can return the appropriate error.
+func ShortCircuit(operator Operator, left *data.Value) (result *data.Value, ok bool) {
+	if operator != And && operator != Or {
+		return nil, false
+	}
+	if reflect.ValueOf(operatorTable[operator][left.Type]).Pointer() == reflect.ValueOf(o).Pointer() {
+		return nil, false
+	}
+
+	var err error
+	var leftBool *data.Value
+	if err, leftBool = Cast(left, data.Boolean); err != nil {
+		return nil, false
+	}
+	// A falsy left operand decides And, and a truthy left operand decides Or
+	if leftBool.Value.(bool) != (operator == Or) {
+		return nil, false
+	}
+	return &data.Value{
+		Value:  leftBool.Value.(bool),
+		Type:   data.Boolean,
+		Global: left.Global,
+	}, true
+}
+
+// UnaryOperator token captured by the lexer.
+type UnaryOperator int
+
+const (
+	Not UnaryOperator = iota
+	Neg
+	Pos
+)
+
+// unaryOperatorMap is a mapping of UnaryOperator symbols to their respective enumeration value.
+var unaryOperatorMap = map[string]UnaryOperator{
+	"!": Not,
+	"-": Neg,
+	"+": Pos,
+}
+
+// unaryOperatorSymbolMap is a mapping of UnaryOperator enumeration values to their respective symbols.
+var unaryOperatorSymbolMap = map[UnaryOperator]string{
+	Not: "!",
+	Neg: "-",
+	Pos: "+",
+}
+
+// Capture will capture the appropriate UnaryOperator within the referred to UnaryOperator.
+func (u *UnaryOperator) Capture(s []string) error {
+	var ok bool
+	*u, ok = unaryOperatorMap[s[0]]
+	if !ok {
+		panic(fmt.Sprintf("Unsupported unary operator: %s", s[0]))
+	}
+	return nil
+}
+
+// String will return the appropriate symbol for the UnaryOperator using a lookup.
+func (u *UnaryOperator) String() string {
+	return unaryOperatorSymbolMap[*u]
+}
+
+// Compute the result of the operand with the referred unary operator. Internally this calls the package wide
+// ComputeUnary method.
+func (u *UnaryOperator) Compute(op *data.Value) (err error, result *data.Value) {
+	return ComputeUnary(*u, op)
+}
+
+// uo is the "ID" for uoFunc. This needs to be created so that there is an identity that can be checked for equivalence.
+var uo = uoFunc
+
+// unaryOperatorTable is a lookup which contains the functions which carry out unary operation calls. Each row
+// represents the unary operator that is being called. Whereas, each column represents the type of the operand.
+var unaryOperatorTable = [3][10]func(op *data.Value) (err error, result *data.Value){
+	/*           NoType    Object    Array    String    Number    Boolean    Null    Function    Iterable    Future    */
+	/* Not */ {uo, not, not, not, not, not, not, uo, uo, uo},
+	/* Neg */ {uo, neg, neg, neg, neg, neg, neg, uo, uo, uo},
+	/* Pos */ {uo, pos, pos, pos, pos, pos, pos, uo, uo, uo},
+}
+
+// ComputeUnary will compute the result of the given unary operation with the given operand. Internally this uses the
+// unaryOperatorTable and looks up the operator value and the Type of the operand. If the computation for the given
+// operator and type does not exist, the errors.InvalidUnaryOperation error will be thrown. Otherwise, the result of the
+// computation will be returned.
+func ComputeUnary(operator UnaryOperator, operand *data.Value) (err error, result *data.Value) {
+	// If the unaryOperatorTable entry points to uo then we will return an InvalidUnaryOperation error.
+	if reflect.ValueOf(unaryOperatorTable[operator][operand.Type]).Pointer() == reflect.ValueOf(uo).Pointer() {
+		return errors.InvalidUnaryOperation.Errorf(errors.GetNullVM(), operator.String(), operand.Type.String()), nil
+	}
+	// Otherwise, we return the result of the computation method.
+	return unaryOperatorTable[operator][operand.Type](operand)
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/andygello555/gotils/files"
+	"github.com/andygello555/parser"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// LoadCommand is the first argument given to sttp to run a LoadTest rather than a script.
+const LoadCommand = "load"
+
+// DefaultLoadConcurrency is the number of virtual users that a LoadTest runs when no concurrency is given.
+const DefaultLoadConcurrency = 10
+
+// LoadStats records the latency and outcome of each HTTP method call made during a LoadTest. The HTTP method calls are
+// grouped by the parser.MethodCall that made them, so each distinct request within the script has its own statistics.
+// LoadStats is safe to use from multiple goroutines.
+type LoadStats struct {
+	mutex    sync.Mutex
+	requests map[*parser.MethodCall]*requestStats
+	// order contains each parser.MethodCall in the order in which they were first recorded.
+	order []*parser.MethodCall
+}
+
+// requestStats contains the latencies and number of errors for a single parser.MethodCall.
+type requestStats struct {
+	latencies []time.Duration
+	errors    int
+}
+
+// NewLoadStats creates an empty LoadStats.
+func NewLoadStats() *LoadStats {
+	return &LoadStats{requests: make(map[*parser.MethodCall]*requestStats)}
+}
+
+// Record records that the given parser.MethodCall took the given amount of time to make, and whether it failed.
+func (ls *LoadStats) Record(method *parser.MethodCall, elapsed time.Duration, err error) {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	stats, ok := ls.requests[method]
+	if !ok {
+		stats = &requestStats{}
+		ls.requests[method] = stats
+		ls.order = append(ls.order, method)
+	}
+	stats.latencies = append(stats.latencies, elapsed)
+	if err != nil {
+		stats.errors++
+	}
+}
+
+// RecordCall records the given HTTP method call in the VM's LoadStats, if it has any.
+func (vm *VM) RecordCall(method *parser.MethodCall, elapsed time.Duration, err error) {
+	if vm.LoadStats != nil {
+		vm.LoadStats.Record(method, elapsed, err)
+	}
+}
+
+// percentile returns the p-th percentile of the given sorted latencies using the nearest-rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// milliseconds converts the given time.Duration to a number of milliseconds.
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// RequestReport contains the statistics for a single distinct request within a LoadReport.
+type RequestReport struct {
+	// Request is the parser.MethodCall that made the request, as it appears in the script.
+	Request string `json:"request"`
+	// Pos is the position of the parser.MethodCall within the script.
+	Pos       string  `json:"pos"`
+	Count     int     `json:"count"`
+	Errors    int     `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	// Throughput is the number of requests made per second.
+	Throughput float64 `json:"throughput"`
+	P50        float64 `json:"p50_ms"`
+	P90        float64 `json:"p90_ms"`
+	P99        float64 `json:"p99_ms"`
+}
+
+// LoadReport is the outcome of a LoadTest.
+type LoadReport struct {
+	Script string `json:"script"`
+	// Elapsed is the number of seconds that the LoadTest took to run.
+	Elapsed     float64 `json:"elapsed"`
+	Concurrency int     `json:"concurrency"`
+	Iterations  int     `json:"iterations"`
+	// Failed is the number of iterations that returned an error.
+	Failed int `json:"failed"`
+	// FirstError is the error returned by the first iteration that failed.
+	FirstError string `json:"first_error,omitempty"`
+	// Throughput is the number of iterations completed per second.
+	Throughput float64          `json:"throughput"`
+	Requests   []*RequestReport `json:"requests"`
+}
+
+// Report constructs a RequestReport for each distinct request within the LoadStats, in the order that they were first
+// recorded. The given elapsed time.Duration is used to calculate throughput.
+func (ls *LoadStats) Report(elapsed time.Duration) []*RequestReport {
+	ls.mutex.Lock()
+	defer ls.mutex.Unlock()
+	reports := make([]*RequestReport, len(ls.order))
+	for i, method := range ls.order {
+		stats := ls.requests[method]
+		sorted := make([]time.Duration, len(stats.latencies))
+		copy(sorted, stats.latencies)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+		reports[i] = &RequestReport{
+			Request:    method.String(0),
+			Pos:        method.Pos.String(),
+			Count:      len(sorted),
+			Errors:     stats.errors,
+			ErrorRate:  float64(stats.errors) / float64(len(sorted)),
+			Throughput: float64(len(sorted)) / elapsed.Seconds(),
+			P50:        milliseconds(percentile(sorted, 50)),
+			P90:        milliseconds(percentile(sorted, 90)),
+			P99:        milliseconds(percentile(sorted, 99)),
+		}
+	}
+	return reports
+}
+
+// String returns the LoadReport as a human-readable table.
+func (lr *LoadReport) String() string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf(
+		"load test of %s: %d iterations (%d failed) by %d users in %.3fs, %.2f iterations/s\n",
+		lr.Script, lr.Iterations, lr.Failed, lr.Concurrency, lr.Elapsed, lr.Throughput,
+	))
+	if lr.FirstError != "" {
+		b.WriteString(fmt.Sprintf("first error: %s\n", lr.FirstError))
+	}
+
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "REQUEST\tCOUNT\tERRORS\tREQ/S\tP50\tP90\tP99")
+	for _, r := range lr.Requests {
+		_, _ = fmt.Fprintf(
+			w, "%s %s\t%d\t%d (%.2f%%)\t%.2f\t%.2fms\t%.2fms\t%.2fms\n",
+			r.Pos, r.Request, r.Count, r.Errors, r.ErrorRate*100, r.Throughput, r.P50, r.P90, r.P99,
+		)
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// LoadTest runs a script, or a function within a script, repeatedly using a number of concurrent virtual users. Each
+// virtual user is a worker within a workerPool, the same as the workers of a BatchSuite, and has its own VM that it
+// runs each iteration that it is given on.
+type LoadTest struct {
+	// Filename is the name of the script.
+	Filename string
+	// Program is the parsed script. It is shared between every virtual user.
+	Program *parser.Program
+	// Function is the name of the function within the script to call on each iteration. If this is empty then the
+	// whole script is evaluated on each iteration. Otherwise, the script is evaluated once by each virtual user before
+	// its first iteration.
+	Function string
+	// Concurrency is the number of virtual users.
+	Concurrency int
+	// Iterations is the total number of iterations to run. If this is less than 1 then iterations are run until the
+	// Duration has elapsed.
+	Iterations int
+	// Duration is the maximum amount of time to start new iterations for. If this is not positive then there is no
+	// limit.
+	Duration time.Duration
+	// RampUp is the amount of time over which the virtual users are started. Each virtual user is started at an even
+	// interval within the RampUp.
+	RampUp time.Duration
+	// Stats records the HTTP method calls made by every virtual user.
+	Stats *LoadStats
+	// call is the parsed FunctionCall to Function.
+	call *parser.Program
+}
+
+// NewLoadTest parses the given script and creates a LoadTest for it, or for the given function within it. If neither
+// the number of iterations nor the duration are given, then each virtual user will run a single iteration.
+func NewLoadTest(filename string, script string, function string, concurrency int, iterations int, duration time.Duration, rampUp time.Duration) (err error, lt *LoadTest) {
+	if concurrency < 1 {
+		concurrency = DefaultLoadConcurrency
+	}
+	if iterations < 1 && duration <= 0 {
+		iterations = concurrency
+	}
+
+	lt = &LoadTest{
+		Filename:    filename,
+		Function:    function,
+		Concurrency: concurrency,
+		Iterations:  iterations,
+		Duration:    duration,
+		RampUp:      rampUp,
+		Stats:       NewLoadStats(),
+	}
+	if err, lt.Program = parser.Parse(filename, script); err != nil {
+		return err, nil
+	}
+	if function != "" {
+		if err, lt.call = parser.Parse(filename, fmt.Sprintf("$%s();", function)); err != nil {
+			return err, nil
+		}
+	}
+	return nil, lt
+}
+
+// Run runs the LoadTest and returns its LoadReport.
+func (lt *LoadTest) Run() *LoadReport {
+	report := &LoadReport{
+		Script:      lt.Filename,
+		Concurrency: lt.Concurrency,
+	}
+
+	pool := newWorkerPool(lt.Concurrency)
+	var deadline <-chan time.Time
+	if lt.Duration > 0 {
+		timer := time.NewTimer(lt.Duration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	// Each virtual user reports the error of each iteration that it runs
+	var resultMutex sync.Mutex
+	results := func(err error) {
+		resultMutex.Lock()
+		defer resultMutex.Unlock()
+		report.Iterations++
+		if err != nil {
+			if report.Failed == 0 {
+				report.FirstError = err.Error()
+			}
+			report.Failed++
+		}
+	}
+
+	start := time.Now()
+	pool.start(lt.Concurrency, func(u int, jobs <-chan interface{}) {
+		lt.user(time.Duration(u)*lt.RampUp/time.Duration(lt.Concurrency), jobs, results)
+	})
+
+	// Iterations are fed to the virtual users until there are no more iterations, or the duration has elapsed
+feed:
+	for i := 0; lt.Iterations < 1 || i < lt.Iterations; i++ {
+		select {
+		case pool.jobs <- i:
+		case <-deadline:
+			break feed
+		}
+	}
+	pool.stop()
+
+	elapsed := time.Since(start)
+	report.Elapsed = elapsed.Seconds()
+	report.Throughput = float64(report.Iterations) / elapsed.Seconds()
+	report.Requests = lt.Stats.Report(elapsed)
+	return report
+}
+
+// user is the worker routine for a single virtual user. It waits for the given delay, then runs an iteration for each
+// job it receives.
+func (lt *LoadTest) user(delay time.Duration, jobs <-chan interface{}, results func(err error)) {
+	time.Sleep(delay)
+
+	vm := New(lt.call != nil, nil, ioutil.Discard, ioutil.Discard, nil)
+	var setupErr error
+	if lt.call != nil {
+		// The script is evaluated once to define the function. HTTP method calls made here are not recorded.
+		setupErr, _ = lt.Program.Eval(vm)
+	}
+	vm.LoadStats = lt.Stats
+
+	for range jobs {
+		if setupErr != nil {
+			results(setupErr)
+			continue
+		}
+
+		var err error
+		if lt.call != nil {
+			// Only the bottommost stack frame, which contains the function, is kept between iterations
+			*vm.CallStack = (*vm.CallStack)[:1]
+			vm.Scope = 0
+			err, _ = lt.call.Eval(vm)
+		} else {
+			cs := make(CallStack, 0)
+			vm.CallStack = &cs
+			vm.Scope = 0
+			err, _ = lt.Program.Eval(vm)
+		}
+		results(err)
+	}
+}
+
+// Load is the entrypoint for the load command. It parses the given command line arguments, runs the LoadTest, and then
+// writes the LoadReport to stdout. The exit code for sttp is returned.
+func Load(args []string, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet(LoadCommand, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "usage: sttp %s [ FILE | INPUT ] [ OPTIONS ]\n", LoadCommand)
+		flags.PrintDefaults()
+	}
+	concurrency := flags.Int("concurrency", DefaultLoadConcurrency, "number of virtual users that run iterations at once")
+	iterations := flags.Int("iterations", 0, "total number of iterations to run (default: one per virtual user, unless a duration is given)")
+	duration := flags.Duration("duration", 0, "maximum amount of time to start iterations for, e.g. 30s")
+	rampUp := flags.Duration("ramp-up", 0, "amount of time over which the virtual users are started, e.g. 5s")
+	function := flags.String("function", "", "name of a function within the script to call on each iteration, rather than running the whole script")
+	asJSON := flags.Bool("json", false, "write the report as JSON")
+
+	// Options can be given both before and after the script
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+	sourceFileOrScript := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[1:]); err != nil {
+		return 2
+	}
+
+	filename, script := "stdin", sourceFileOrScript
+	if files.IsFile(sourceFileOrScript) && !files.IsDir(sourceFileOrScript) {
+		filename = sourceFileOrScript
+		sByte, _ := ioutil.ReadFile(sourceFileOrScript)
+		script = string(sByte)
+	}
+
+	err, lt := NewLoadTest(filename, script, *function, *concurrency, *iterations, *duration, *rampUp)
+	if err != nil {
+		_, _ = fmt.Fprintf(stderr, "Error occurred whilst parsing \"%s\": %v\n", sourceFileOrScript, err)
+		return 1
+	}
+
+	report := lt.Run()
+	if *asJSON {
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(report); err != nil {
+			_, _ = fmt.Fprintf(stderr, "Error occurred whilst writing report: %v\n", err)
+			return 1
+		}
+	} else {
+		_, _ = fmt.Fprint(stdout, report.String())
+	}
+	return 0
+}
@@ -2,16 +2,21 @@ package main
 
 import (
 	"container/heap"
+	"context"
 	"fmt"
 	"github.com/andygello555/data"
+	"github.com/andygello555/errors"
 	"github.com/andygello555/eval"
 	"github.com/andygello555/parser"
 	"io/fs"
 	"io/ioutil"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -23,6 +28,7 @@ const (
 	ExampleTestSuitePath = "_examples/test_suites"
 	EchoChamberCmd       = "node"
 	EchoChamberSource    = "_examples/echo_chamber/main.js"
+	EchoChamberAddr      = "127.0.0.1:3000"
 )
 
 type example struct {
@@ -43,7 +49,13 @@ func startServer() *exec.Cmd {
 	if err := echoChamber.Start(); err != nil {
 		panic(fmt.Errorf("could not start echo chamber: \"%s\"", err.Error()))
 	}
-	time.Sleep(150 * time.Millisecond)
+	// Wait for the echo chamber to start listening, as node can take a while to start up
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", EchoChamberAddr); err == nil {
+			_ = conn.Close()
+			break
+		}
+	}
 	return echoChamber
 }
 
@@ -95,6 +107,9 @@ func init() {
 	}
 }
 
+// blockCommentRegex matches block comments, which are dropped when parsing.
+var blockCommentRegex = regexp.MustCompile(`(?s)/\*.*?\*/`)
+
 func TestParse(t *testing.T) {
 	for testNo, e := range examples {
 		err, p := parser.Parse(e.name, e.script)
@@ -104,7 +119,7 @@ func TestParse(t *testing.T) {
 
 		//fmt.Println(testNo, ">>>>>>>>")
 		//fmt.Println(p.String(0))
-		expectedLines := strings.Split(e.script, "\n")
+		expectedLines := strings.Split(blockCommentRegex.ReplaceAllString(e.script, ""), "\n")
 		for i, line := range expectedLines {
 			expectedLines[i] = strings.TrimLeft(line, "\n\t ")
 			if strings.HasPrefix(expectedLines[i], "//") {
@@ -453,7 +468,7 @@ func TestBatchSuite_Execute(t *testing.T) {
 			},
 		},
 	} {
-		batch := Batch(nil)
+		batch := Batch(nil, nil)
 		batch.Start(-1)
 		for _, item := range test.items {
 			batch.AddWork(item.Method, item.Args...)
@@ -490,6 +505,825 @@ func TestBatchSuite_Execute(t *testing.T) {
 	killServer(echoChamber)
 }
 
+func TestBatchSuite_Policies(t *testing.T) {
+	// Start the echo chamber web server
+	echoChamber := startServer()
+
+	// With a single worker, the first BatchItem fails before any of the others are executed, so every other BatchItem
+	// should be cancelled because of it
+	urls := []string{
+		"http://127.0.0.1:1/refused",
+		"http://127.0.0.1:3000/a",
+		"http://127.0.0.1:3000/b",
+		"http://127.0.0.1:3000/c",
+	}
+	batch := Batch(&parser.Batch{FailFast: true}, nil)
+	batch.Start(1)
+	for _, url := range urls {
+		batch.AddWork(&parser.MethodCall{Method: eval.GET}, &data.Value{Value: url, Type: data.String})
+	}
+	batch.Stop()
+	for i, result := range batch.Collect() {
+		if result.GetErr() == nil {
+			t.Errorf("fail fast result no. %d has no error", i+1)
+			continue
+		}
+		errVal, _ := errors.ConstructSttpError(result.GetErr(), nil)
+		if cancelled := errVal.(map[string]interface{})["type"] == "BatchCancelled"; cancelled != (i > 0) {
+			t.Errorf("fail fast result no. %d has error %q, expected it to be cancelled: %t", i+1, result.GetErr().Error(), i > 0)
+		}
+	}
+	if err := batch.Err(); err == nil || err != batch.Collect()[0].GetErr() {
+		t.Errorf("fail fast batch has error %v, expected the error of the first result", err)
+	}
+
+	// Each object collected by "collect into" contains the call, and either the response or the error of the call
+	var stdout, stderr strings.Builder
+	vm := New(false, nil, &stdout, &stderr, nil)
+	err, _ := vm.Eval("batch_policies", `batch this collect into results
+	a = $GET("http://127.0.0.1:3000/a");
+	refused = $GET("http://127.0.0.1:1/refused");
+	$print(refused);
+end
+$print(0 + results);
+for i, result in results do
+	$print(result.call, result.response == null, result.error == null);
+end
+$print(results[0].response.content.url, results[1].error.subset);`)
+	if err != nil {
+		t.Errorf("collect into script returned an unexpected error: %v", err)
+	}
+	expected := `null
+2
+$GET("http://127.0.0.1:3000/a") false true
+$GET("http://127.0.0.1:1/refused") true false
+http://127.0.0.1:3000/a go
+`
+	if stdout.String() != expected {
+		t.Errorf("collect into script stdout %q does not match expected: %q", stdout.String(), expected)
+	}
+
+	// Kill the echo chamber
+	killServer(echoChamber)
+}
+
+func TestBatchSuite_Stats(t *testing.T) {
+	// Start the echo chamber web server
+	echoChamber := startServer()
+
+	urls := []string{
+		"http://127.0.0.1:3000/a",
+		"http://127.0.0.1:3000/b",
+		"http://127.0.0.1:3000/c",
+		"http://127.0.0.1:1/refused",
+	}
+	var progress strings.Builder
+	batch := Batch(nil, nil)
+	batch.Progress = &progress
+	batch.Start(2)
+	for _, url := range urls {
+		batch.AddWork(&parser.MethodCall{Method: eval.GET}, &data.Value{Value: url, Type: data.String})
+	}
+	batch.Stop()
+	stats := batch.Stats().Value.(map[string]interface{})
+
+	if stats["requests"] != float64(len(urls)) || stats["failed"] != 1.0 || stats["workers"] != 2.0 {
+		t.Errorf("stats has %v requests (%v failed) and %v workers, expected %d requests (1 failed) and 2 workers", stats["requests"], stats["failed"], stats["workers"], len(urls))
+	}
+	if concurrency := stats["max_concurrency"].(float64); concurrency < 1 || concurrency > 2 {
+		t.Errorf("max_concurrency is %v, expected it to be between 1 and the number of workers", concurrency)
+	}
+	for i, call := range stats["calls"].([]interface{}) {
+		c := call.(map[string]interface{})
+		enqueued, started, finished := c["enqueued_ms"].(float64), c["started_ms"].(float64), c["finished_ms"].(float64)
+		if enqueued > started || started > finished || finished > stats["wall_ms"].(float64) {
+			t.Errorf("call no. %d has unordered times: enqueued %v, started %v, finished %v, wall %v", i+1, enqueued, started, finished, stats["wall_ms"])
+		}
+		if c["failed"] != (i == len(urls)-1) {
+			t.Errorf("call no. %d has failed set to %v", i+1, c["failed"])
+		}
+	}
+	// The batch finished before ProgressDelay so no progress should have been written
+	if progress.Len() != 0 {
+		t.Errorf("progress was written for a batch that took less than %s: %q", ProgressDelay.String(), progress.String())
+	}
+
+	// Kill the echo chamber
+	killServer(echoChamber)
+}
+
+func TestBatch_Promises(t *testing.T) {
+	// Start the echo chamber web server
+	echoChamber := startServer()
+
+	for testNo, test := range []struct {
+		script string
+		stdout string
+		err    bool
+		// within is how long the script should take at most. It is not checked if it is zero.
+		within time.Duration
+	}{
+		{
+			// The block is only evaluated once, so side effects only happen once
+			script: `batch this
+	a = $GET("http://127.0.0.1:3000/a");
+	$print("once");
+	$print(a.content.url);
+end`,
+			stdout: "once\nhttp://127.0.0.1:3000/a\n",
+		},
+		{
+			// Counters, appends, and output within a batch behave exactly as they do outside one, and output appears in
+			// program order
+			script: `count = 0;
+urls = [];
+batch this
+	for i = 0; i < 3; i = i + 1 do
+		$print("queue", i);
+		responses[i] = $GET("http://127.0.0.1:3000/order/" + i);
+		count = count + 1;
+		urls = urls + ["http://127.0.0.1:3000/order/" + i];
+	end
+	$print("count", count);
+	$print(responses[1].content.url);
+	$print("after read");
+end
+$print(count, urls[2], responses[0].content.url, responses[2].content.url);`,
+			stdout: "queue 0\nqueue 1\nqueue 2\ncount 3\nhttp://127.0.0.1:3000/order/1\nafter read\n3 http://127.0.0.1:3000/order/2 http://127.0.0.1:3000/order/0 http://127.0.0.1:3000/order/2\n",
+		},
+		{
+			// Statements after a failed read are not evaluated, and output before it is not thrown away
+			script: `batch this
+	a = $GET("http://127.0.0.1:1/refused");
+	$print("before");
+	$print(a.code);
+	$print("after");
+end`,
+			stdout: "before\n",
+			err:    true,
+		},
+		{
+			// Method calls that have not finished by the deadline time out, and their errors can be caught
+			script: `batch this with 1 within 0.25
+	slow = $GET("http://127.0.0.1:3000/slow?delay=5000");
+	queued = $GET("http://127.0.0.1:3000/queued");
+	try this
+		$print(slow.content.url);
+	catch as err do
+		$print(err.type);
+	end
+	try this
+		$print(queued.content.url);
+	catch as err do
+		$print(err.type);
+	end
+end
+batch this within 5
+	fast = $GET("http://127.0.0.1:3000/fast");
+end
+$print(fast.content.url);`,
+			stdout: "BatchTimeout\nBatchTimeout\nhttp://127.0.0.1:3000/fast\n",
+		},
+		{
+			// The deadline must be positive
+			script: `batch this within 0
+	$print("never");
+end`,
+			err: true,
+		},
+		{
+			// The error of a failed request is thrown where it is read, so it can be caught within the batch
+			script: `batch this
+	a = $GET("http://127.0.0.1:1/refused");
+	b = $GET("http://127.0.0.1:3000/b");
+	try this
+		$print(a.code);
+	catch as e do
+		$print("caught");
+	end
+	$print(b.code);
+end
+$print(a);`,
+			stdout: "caught\n200\nnull\n",
+		},
+		{
+			// The error of a failed request that is never read is thrown by the batch statement
+			script: `batch this
+	$GET("http://127.0.0.1:1/refused");
+	$print("unread");
+end`,
+			stdout: "unread\n",
+			err:    true,
+		},
+		{
+			// The stats of the last batch can be read after it has finished, and cannot be modified
+			script: `$print($batch_stats());
+batch this with 2
+	a = $GET("http://127.0.0.1:3000/a");
+	b = $GET("http://127.0.0.1:3000/b");
+end
+stats = $batch_stats();
+$print(stats.requests, stats.failed, stats.workers, stats.calls[1].call);
+stats.requests = 100;
+stats = $batch_stats();
+$print(stats.requests);`,
+			stdout: "null\n2 0 2 $GET(\"http://127.0.0.1:3000/b\")\n2\n",
+		},
+		{
+			// Setting a Promise within an Array or an Object does not wait for the Promises already within it
+			script: `batch this with 7
+	for i = 0; i < 5; i = i + 1 do
+		pages[i] = $GET("http://127.0.0.1:3000/page/" + i + "?delay=400");
+	end
+	result.a = $GET("http://127.0.0.1:3000/a?delay=400");
+	result.b = $GET("http://127.0.0.1:3000/b?delay=400");
+end
+$print(pages[4].content.url, result.b.content.url);`,
+			stdout: "http://127.0.0.1:3000/page/4?delay=400 http://127.0.0.1:3000/b?delay=400\n",
+			within: time.Second,
+		},
+	} {
+		var stdout, stderr strings.Builder
+		vm := New(false, nil, &stdout, &stderr, nil)
+		start := time.Now()
+		err, _ := vm.Eval("batch_promises", test.script)
+		if elapsed := time.Since(start); test.within > 0 && elapsed > test.within {
+			t.Errorf("test no. %d: took %s which is longer than %s", testNo+1, elapsed.String(), test.within.String())
+		}
+		if (err != nil) != test.err {
+			t.Errorf("test no. %d: error %v was not expected (expected an error: %t)", testNo+1, err, test.err)
+		}
+		if stdout.String() != test.stdout {
+			t.Errorf("test no. %d: stdout %q does not match expected: %q", testNo+1, stdout.String(), test.stdout)
+		}
+		if vm.Batch != nil {
+			t.Errorf("test no. %d: batch was not deleted", testNo+1)
+		}
+	}
+
+	// Kill the echo chamber
+	killServer(echoChamber)
+}
+
+func TestBatch_Settle(t *testing.T) {
+	echoChamber := startServer()
+	defer killServer(echoChamber)
+
+	// The module is written to a temporary directory so that it can be imported
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "cache.sttp"), []byte(`page = $GET("http://127.0.0.1:3000/module");
+fun get()
+    return page;
+end`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for testNo, test := range []struct {
+		script string
+		stdout string
+	}{
+		{
+			// Promises assigned to the global values of a module that is imported within a batch are settled when the batch
+			// finishes
+			script: `batch this
+	cache = $import("cache.sttp");
+end
+page = $cache.get();
+$print(page.content.url);`,
+			stdout: "http://127.0.0.1:3000/module\n",
+		},
+		{
+			// Promises assigned to the variables captured by an anonymous function are settled when the batch finishes
+			script: `fun make()
+    last = null;
+    return {
+        "fetch": fun (url)
+            last = $GET(url);
+        end,
+        "get": fun ()
+            return last;
+        end
+    };
+end
+cache = $make();
+batch this
+	$cache.fetch("http://127.0.0.1:3000/closure");
+end
+last = $cache.get();
+$print(last.content.url);`,
+			stdout: "http://127.0.0.1:3000/closure\n",
+		},
+	} {
+		var stdout, stderr strings.Builder
+		vm := New(false, nil, &stdout, &stderr, nil)
+		if err, _ := vm.Eval(filepath.Join(dir, "main.sttp"), test.script); err != nil {
+			t.Errorf("test no. %d: error %v was not expected", testNo+1, err)
+		}
+		if stdout.String() != test.stdout {
+			t.Errorf("test no. %d: stdout %q does not match expected: %q", testNo+1, stdout.String(), test.stdout)
+		}
+	}
+}
+
+func TestParallel_Eval(t *testing.T) {
+	// Start the echo chamber web server
+	echoChamber := startServer()
+
+	// fetch requests the given path after the given delay, printing before and after, and returns the URL it fetched
+	const fetch = `fun fetch(path, delay)
+	$print("fetching", path);
+	response = $GET("http://127.0.0.1:3000/" + path + "?delay=" + delay);
+	$print("fetched", path);
+	return response.content.url;
+end
+fun fail(reason, delay)
+	$GET("http://127.0.0.1:3000/fail?delay=" + delay);
+	throw {"reason": reason};
+end
+`
+
+	for testNo, test := range []struct {
+		script string
+		stdout string
+		err    bool
+		// within is how long the script should take at most. It is not checked if it is zero.
+		within time.Duration
+		// after is how long the script should take at least. It is not checked if it is zero.
+		after time.Duration
+	}{
+		{
+			// Output is written, and results are assigned, in the order that the function calls appear within the
+			// block, even though the first function call finishes last
+			script: fetch + `parallel this
+	first = $fetch("first", 400);
+	second = $fetch("second", 0);
+	$fetch("third", 200);
+end
+$print(first, second);`,
+			stdout: "fetching first\nfetched first\nfetching second\nfetched second\nfetching third\nfetched third\nhttp://127.0.0.1:3000/first?delay=400 http://127.0.0.1:3000/second?delay=0\n",
+			within: 700 * time.Millisecond,
+		},
+		{
+			// Results can be assigned to properties and indices
+			script: fetch + `results = [null, null];
+parallel this with 2
+	results[1] = $fetch("b", 0);
+	results[0] = $fetch("a", 0);
+	object.c = $fetch("c", 0);
+end
+$print(results[0], results[1], object.c);`,
+			stdout: "fetching b\nfetched b\nfetching a\nfetched a\nfetching c\nfetched c\nhttp://127.0.0.1:3000/a?delay=0 http://127.0.0.1:3000/b?delay=0 http://127.0.0.1:3000/c?delay=0\n",
+		},
+		{
+			// No more function calls than the number of workers are evaluated at once
+			script: fetch + `parallel this with 1
+	a = $fetch("a", 200);
+	b = $fetch("b", 200);
+	c = $fetch("c", 200);
+end
+$print(a, b, c);`,
+			stdout: "fetching a\nfetched a\nfetching b\nfetched b\nfetching c\nfetched c\nhttp://127.0.0.1:3000/a?delay=200 http://127.0.0.1:3000/b?delay=200 http://127.0.0.1:3000/c?delay=200\n",
+			after:  600 * time.Millisecond,
+		},
+		{
+			// Without a number of workers, every function call is evaluated at once
+			script: fetch + `parallel this
+	a = $fetch("a", 300);
+	b = $fetch("b", 300);
+	c = $fetch("c", 300);
+end
+$print(a, b, c);`,
+			stdout: "fetching a\nfetched a\nfetching b\nfetched b\nfetching c\nfetched c\nhttp://127.0.0.1:3000/a?delay=300 http://127.0.0.1:3000/b?delay=300 http://127.0.0.1:3000/c?delay=300\n",
+			within: 800 * time.Millisecond,
+		},
+		{
+			// The error of the first function call within the block that fails is thrown, even if a later one failed
+			// first, and the results of the function calls that did not fail are still assigned
+			script: fetch + `a = "unassigned";
+try this
+	parallel this
+		a = $fail("first", 300);
+		b = $fail("second", 0);
+		c = $fetch("c", 0);
+	end
+catch as err do
+	$print(err.reason, a, c);
+end`,
+			stdout: "fetching c\nfetched c\nfirst unassigned http://127.0.0.1:3000/c?delay=0\n",
+		},
+		{
+			// Uncaught errors are thrown by the parallel statement
+			script: fetch + `parallel this
+	$fail("uncaught", 0);
+end`,
+			err: true,
+		},
+		{
+			// There must be at least one worker
+			script: fetch + `parallel this with 0
+	$fetch("never", 0);
+end`,
+			err: true,
+		},
+		{
+			// Only function calls can be run in parallel
+			script: `parallel this
+	x = 1;
+end`,
+			err: true,
+		},
+	} {
+		var stdout, stderr strings.Builder
+		vm := New(false, nil, &stdout, &stderr, nil)
+		start := time.Now()
+		err, _ := vm.Eval("parallel", test.script)
+		elapsed := time.Since(start)
+		if test.within > 0 && elapsed > test.within {
+			t.Errorf("test no. %d: took %s which is longer than %s", testNo+1, elapsed.String(), test.within.String())
+		}
+		if test.after > 0 && elapsed < test.after {
+			t.Errorf("test no. %d: took %s which is shorter than %s", testNo+1, elapsed.String(), test.after.String())
+		}
+		if (err != nil) != test.err {
+			t.Errorf("test no. %d: error %v was not expected (expected an error: %t)", testNo+1, err, test.err)
+		}
+		if stdout.String() != test.stdout {
+			t.Errorf("test no. %d: stdout %q does not match expected: %q", testNo+1, stdout.String(), test.stdout)
+		}
+	}
+
+	// Kill the echo chamber
+	killServer(echoChamber)
+}
+
+func TestAsyncPool_Go(t *testing.T) {
+	pool := NewAsyncPool(1)
+	started := make(chan struct{})
+	running := pool.Go(func(ctx context.Context) (err error, result *data.Value) {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err(), nil
+	})
+	<-started
+	var executed int32
+	queued := pool.Go(func(ctx context.Context) (err error, result *data.Value) {
+		atomic.StoreInt32(&executed, 1)
+		return nil, nil
+	})
+
+	// Cancelling work that is waiting for a worker means that it is never executed
+	data.Cancel(queued)
+	if err, _, ok := data.AwaitWithin(queued, time.Second); !ok || err != context.Canceled {
+		t.Errorf("queued work settled to (%v, %t), expected (%v, true)", err, ok, context.Canceled)
+	}
+	data.Cancel(running)
+	if err, _, ok := data.AwaitWithin(running, time.Second); !ok || err != context.Canceled {
+		t.Errorf("running work settled to (%v, %t), expected (%v, true)", err, ok, context.Canceled)
+	}
+	if atomic.LoadInt32(&executed) == 1 {
+		t.Errorf("queued work was executed after being cancelled")
+	}
+
+	// A worker is freed once the work that it is executing is cancelled
+	echoChamber := startServer()
+	defer killServer(echoChamber)
+	var stdout, stderr strings.Builder
+	vm := New(false, nil, &stdout, &stderr, nil)
+	vm.Pool = NewAsyncPool(1)
+	err, _ := vm.Eval("async_pool", `try this
+	$await($async($GET("http://127.0.0.1:3000/slow?delay=5000")), 0.2);
+catch as err do
+	$print(err.type);
+end
+fast = $await($async($GET("http://127.0.0.1:3000/fast")), 1);
+$print(fast.code);`)
+	if err != nil {
+		t.Errorf("error %v was not expected", err)
+	}
+	if expected := "AwaitError\n200\n"; stdout.String() != expected {
+		t.Errorf("stdout %q does not match expected: %q", stdout.String(), expected)
+	}
+}
+
+func TestVM_Import(t *testing.T) {
+	// The modules are written to a temporary directory so that relative imports can be checked
+	dir := t.TempDir()
+	for name, script := range map[string]string{
+		"lib/auth.sttp": `base = "http://127.0.0.1:3000";
+fun url(path)
+    return base + path;
+end
+fun login(user)
+    return {"url": $url("/login"), "user": user};
+end
+$print("loaded auth");`,
+		"lib/users.sttp": `auth = $import("auth.sttp");
+fun get(user)
+    login = $auth.login(user);
+    return login.user;
+end`,
+		"cycle/a.sttp": `b = $import("b.sttp");`,
+		"cycle/b.sttp": `a = $import("a.sttp");`,
+		"throws.sttp":  `throw "bad module";`,
+		"broken.sttp":  `x = ;`,
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for testNo, test := range []struct {
+		script string
+		stdout string
+		err    bool
+	}{
+		{
+			// Functions within a module can refer to the module's global values, and the module is only evaluated once
+			script: `auth = $import("lib/auth.sttp");
+again = $import("./lib/auth.sttp");
+login = $auth.login("alice");
+$print(login.url, login.user, again.base);`,
+			stdout: "loaded auth\nhttp://127.0.0.1:3000/login alice http://127.0.0.1:3000\n",
+		},
+		{
+			// Modules resolve their imports relative to themselves, and cannot see the variables of their importer
+			script: `base = "http://example.com";
+users = $import("lib/users.sttp");
+$print($users.get("bob"), base);`,
+			stdout: "loaded auth\nbob http://example.com\n",
+		},
+		{
+			// Modifying a namespace does not modify the namespaces returned by later imports
+			script: `auth = $import("lib/auth.sttp");
+auth.base = "changed";
+auth = $import("lib/auth.sttp");
+$print(auth.base);`,
+			stdout: "loaded auth\nhttp://127.0.0.1:3000\n",
+		},
+		{
+			script: `fun f()
+    return $import("lib/auth.sttp");
+end
+auth = $f();
+$print($auth.url("/"));`,
+			stdout: "loaded auth\nhttp://127.0.0.1:3000/\n",
+		},
+		{
+			script: `a = $import("cycle/a.sttp");`,
+			err:    true,
+		},
+		{
+			script: `try this
+    $import("missing.sttp");
+catch as e do
+    $print(e.type);
+end
+try this
+    $import("throws.sttp");
+catch as e do
+    $print(e);
+end`,
+			stdout: "ImportError\nbad module\n",
+		},
+		{
+			script: `$import("broken.sttp");`,
+			err:    true,
+		},
+	} {
+		var stdout, stderr strings.Builder
+		vm := New(false, nil, &stdout, &stderr, nil)
+		err, _ := vm.Eval(filepath.Join(dir, "main.sttp"), test.script)
+		if (err != nil) != test.err {
+			t.Errorf("test no. %d: error %v was not expected (expected an error: %t)", testNo+1, err, test.err)
+		}
+		if stdout.String() != test.stdout {
+			t.Errorf("test no. %d: stdout %q does not match expected: %q", testNo+1, stdout.String(), test.stdout)
+		}
+		if err == nil && vm.CallStack.Size() != 0 {
+			t.Errorf("test no. %d: %d stack frames were left on the call stack", testNo+1, vm.CallStack.Size())
+		}
+	}
+}
+
+func TestCallStack_Call(t *testing.T) {
+	for testNo, test := range []struct {
+		script string
+		stdout string
+		err    bool
+	}{
+		{
+			// Defaults are evaluated within the new stack frame so they can refer to earlier parameters
+			script: `fun url(path, base = "http://127.0.0.1:3000", full = base + path)
+    return full;
+end
+$print($url("/a"), $url("/b", "http://example.com"), $url("/c", full = "overridden"));`,
+			stdout: "http://127.0.0.1:3000/a http://example.com/b overridden\n",
+		},
+		{
+			// Rest parameters collect the positional arguments that are left over
+			script: `fun extra(first, ...rest)
+    return rest;
+end
+$print($extra(1), $extra(1, 2, 3));`,
+			stdout: "[] [2,3]\n",
+		},
+		{
+			// A default that fails does not leave the function's stack frame on the call stack
+			script: `caught = 0;
+fun f(x = $nope())
+    return x;
+end
+for i = 0; i < 3000; i = i + 1 do
+    try this
+        $f();
+    catch as err do
+        caught = caught + 1;
+    end
+end
+$print(caught, $f(1));`,
+			stdout: "3000 1\n",
+		},
+		{
+			// A named argument that does not match a parameter does not leave a stack frame on the call stack either
+			script: `fun f(x)
+    return x;
+end
+for i = 0; i < 3000; i = i + 1 do
+    try this
+        $f(y = 1);
+    catch as err do
+        type = err.type;
+    end
+end
+$print(type, $f(x = 2));`,
+			stdout: "ArgumentError 2\n",
+		},
+		{
+			// Anonymous functions capture the variables of the stack frame that they were created within by reference
+			script: `fun make()
+    count = 0;
+    inc = fun (by = 1)
+        count = count + by;
+        return count;
+    end;
+    $inc();
+    $inc(by = 2);
+    return count;
+end
+$print($make());`,
+			stdout: "3\n",
+		},
+		{
+			// The parameters of an anonymous function do not need to be separated from fun by whitespace
+			script: `fun double(x)
+    return x * 2;
+end
+funny = fun(x) return $double(x); end;
+fun apply(f, x)
+    return $f(x);
+end
+$print($funny(2), $apply(fun(x) return x + 1; end, 2));`,
+			stdout: "4 3\n",
+		},
+		{
+			// Parameters shadow the captured variables, and the captured variables are left untouched
+			script: `x = 1;
+f = fun (x)
+    x = x + 1;
+    return x;
+end;
+$print($f(10), x);`,
+			stdout: "11 1\n",
+		},
+		{
+			// Forked VMs are given their own copy of the variables that anonymous functions capture
+			script: `count = 0;
+inc = fun ()
+    for i = 0; i < 2000; i = i + 1 do
+        count = count + 1;
+    end
+    return count;
+end;
+fun counter()
+    n = 0;
+    return fun ()
+        n = n + 1;
+        return n;
+    end;
+end
+next = $counter();
+$print($await_all([$async($inc()), $async($inc())]), count);
+$print($await_all([$async($next()), $async($next())]), $next());
+parallel this
+    a = $inc();
+    b = $inc();
+end
+$print(a, b, count);`,
+			stdout: "[2000,2000] 0\n[1,1] 1\n2000 2000 0\n",
+		},
+		{
+			script: `fun f(x)
+    return x;
+end
+$f(1, 2);`,
+			err: true,
+		},
+		{
+			script: `fun f(x = $nope())
+    return x;
+end
+$f();`,
+			err: true,
+		},
+	} {
+		var stdout, stderr strings.Builder
+		vm := New(false, nil, &stdout, &stderr, nil)
+		err, _ := vm.Eval("call_stack", test.script)
+		if (err != nil) != test.err {
+			t.Errorf("test no. %d: error %v was not expected (expected an error: %t)", testNo+1, err, test.err)
+		}
+		if stdout.String() != test.stdout {
+			t.Errorf("test no. %d: stdout %q does not match expected: %q", testNo+1, stdout.String(), test.stdout)
+		}
+		if err == nil && vm.CallStack.Size() != 0 {
+			t.Errorf("test no. %d: %d stack frames were left on the call stack", testNo+1, vm.CallStack.Size())
+		}
+	}
+}
+
+func TestProgram_Validate(t *testing.T) {
+	for testNo, test := range []struct {
+		script string
+		stdout string
+		err    bool
+	}{
+		{
+			// Keywords do not stop identifiers that start with them from being parsed
+			script: `continued = 1;
+$print(continued);`,
+			stdout: "1\n",
+		},
+		{
+			script: `finallyDone = 1;
+try this
+    $print("try");
+finally do
+    $print(finallyDone);
+end`,
+			stdout: "try\n1\n",
+		},
+		{
+			script: `for i, n in [1, 2, 3] do
+    is n == 2 ? continue; end
+    fun f(x)
+        return x;
+    end
+    $print($f(n));
+end`,
+			stdout: "1\n3\n",
+		},
+		{
+			// A continue statement outside a loop is found before anything is evaluated
+			script: `$print("never");
+is false ? continue; end`,
+			err: true,
+		},
+		{
+			// The body of a function is not within the loops around it
+			script: `for i, n in [1, 2, 3] do
+    fun f()
+        continue;
+    end
+    $print("never");
+end`,
+			err: true,
+		},
+		{
+			script: `while true do
+    f = fun ()
+        continue;
+    end;
+    break;
+end`,
+			err: true,
+		},
+	} {
+		var stdout, stderr strings.Builder
+		vm := New(false, nil, &stdout, &stderr, nil)
+		err, _ := vm.Eval("validate", test.script)
+		if (err != nil) != test.err {
+			t.Errorf("test no. %d: error %v was not expected (expected an error: %t)", testNo+1, err, test.err)
+		}
+		if stdout.String() != test.stdout {
+			t.Errorf("test no. %d: stdout %q does not match expected: %q", testNo+1, stdout.String(), test.stdout)
+		}
+	}
+}
+
 // Benchmarking batches can be done in the following way.
 //  go test -run=XXX -bench="Benchmark(No)?Batch" -benchtime=5x -count=3
 // This will run a batch-less sttp script...
@@ -653,3 +1487,65 @@ func BenchmarkBatch200(b *testing.B) {
 	benchmarkBatch(batchBenchmarkSetup(200), b)
 	killServer(s)
 }
+
+func TestLoadTest_Run(t *testing.T) {
+	// Start the echo chamber web server
+	echoChamber := startServer()
+
+	script := `a = $GET("http://127.0.0.1:3000/load/a");
+batch this
+	for i = 0; i < 3; i = i + 1 do
+		$GET("http://127.0.0.1:3000/load/b/" + i);
+	end
+end
+fun hit()
+	$GET("http://127.0.0.1:1/refused");
+end`
+
+	for testNo, test := range []struct {
+		function   string
+		iterations int
+		failed     int
+		counts     []int
+		errors     []int
+	}{
+		{
+			// The whole script is run on each iteration
+			iterations: 12,
+			counts:     []int{12, 36},
+			errors:     []int{0, 0},
+		},
+		{
+			// Only the function is run on each iteration, so the requests within the script are not recorded
+			function:   "hit",
+			iterations: 5,
+			failed:     5,
+			counts:     []int{5},
+			errors:     []int{5},
+		},
+	} {
+		err, lt := NewLoadTest("load", script, test.function, 3, test.iterations, 0, 0)
+		if err != nil {
+			t.Fatalf("test no. %d: could not create load test: %v", testNo+1, err)
+		}
+		report := lt.Run()
+		if report.Iterations != test.iterations || report.Failed != test.failed {
+			t.Errorf("test no. %d: ran %d iterations (%d failed), expected %d iterations (%d failed)", testNo+1, report.Iterations, report.Failed, test.iterations, test.failed)
+		}
+		if len(report.Requests) != len(test.counts) {
+			t.Errorf("test no. %d: report has %d requests, expected %d", testNo+1, len(report.Requests), len(test.counts))
+			continue
+		}
+		for i, request := range report.Requests {
+			if request.Count != test.counts[i] || request.Errors != test.errors[i] {
+				t.Errorf("test no. %d: request %s was made %d times (%d errors), expected %d times (%d errors)", testNo+1, request.Request, request.Count, request.Errors, test.counts[i], test.errors[i])
+			}
+			if request.P50 > request.P90 || request.P90 > request.P99 {
+				t.Errorf("test no. %d: request %s has unordered percentiles: %v %v %v", testNo+1, request.Request, request.P50, request.P90, request.P99)
+			}
+		}
+	}
+
+	// Kill the echo chamber
+	killServer(echoChamber)
+}
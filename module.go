@@ -0,0 +1,135 @@
+package main
+
+import (
+	"github.com/andygello555/data"
+	"github.com/andygello555/errors"
+	"github.com/andygello555/parser"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Modules caches the namespaces of the modules imported by a VM, by the absolute path of each module. It is shared with
+// every VM forked from the VM, so that each module is only evaluated once.
+type Modules struct {
+	mutex      sync.Mutex
+	namespaces map[string]*data.Value
+}
+
+// NewModules creates an empty Modules cache.
+func NewModules() *Modules {
+	return &Modules{namespaces: make(map[string]*data.Value)}
+}
+
+// get returns the cached namespace of the module at the given absolute path, or nil if it has not been imported.
+func (m *Modules) get(path string) *data.Value {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.namespaces[path]
+}
+
+// store caches the namespace of the module at the given absolute path. If forked VMs imported the same module at the
+// same time, then the namespace that was stored first is kept and returned.
+func (m *Modules) store(path string, namespace *data.Value) *data.Value {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if existing, ok := m.namespaces[path]; ok {
+		return existing
+	}
+	m.namespaces[path] = namespace
+	return namespace
+}
+
+// Import will evaluate the sttp script at the given path and return its namespace, as constructed by
+// parser.Namespace. A relative path is resolved relative to the directory of the script that is currently being
+// evaluated, or the working directory if the script was not read from a file.
+//
+// The module is evaluated on a new bottommost stack frame that is pushed onto the VM's CallStack, so it cannot see or
+// modify the variables of the importer. The environment is available to the module in the same way as it is to the
+// importer. Importing a module that is currently being imported will return an errors.ImportError describing the
+// cycle.
+func (vm *VM) Import(path string) (err error, namespace *data.Value) {
+	abs := path
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(filepath.Dir(vm.Pos.Filename), abs)
+	}
+	if abs, err = filepath.Abs(abs); err != nil {
+		return errors.ImportError.Errorf(vm, path, err.Error()), nil
+	}
+
+	// The script that started importing modules is part of any cycle as well
+	chain := vm.importing
+	if len(chain) == 0 && vm.Pos.Filename != "" {
+		if root, rootErr := filepath.Abs(vm.Pos.Filename); rootErr == nil {
+			chain = []string{root}
+		}
+	}
+	for i, importing := range chain {
+		if importing == abs {
+			cycle := append(append([]string{}, chain[i:]...), abs)
+			return errors.ImportError.Errorf(vm, path, "import cycle "+strings.Join(cycle, " -> ")), nil
+		}
+	}
+
+	if namespace = vm.Modules.get(abs); namespace != nil {
+		return nil, namespace
+	}
+
+	var b []byte
+	if b, err = ioutil.ReadFile(abs); err != nil {
+		return errors.ImportError.Errorf(vm, path, err.Error()), nil
+	}
+
+	var program *parser.Program
+	if err, program = parser.Parse(abs, string(b)); err != nil {
+		return err, nil
+	}
+	if err = program.Validate(vm); err != nil {
+		return err, nil
+	}
+
+	// The module is evaluated as if it were the outermost script, so that its values are global to its functions
+	pos, scope, importing := vm.Pos, vm.Scope, vm.importing
+	vm.Scope, vm.importing = 0, append(chain, abs)
+	defer func() {
+		vm.Pos, vm.Scope, vm.importing = pos, scope, importing
+	}()
+
+	if err = vm.CallStack.Call(nil, nil, vm, nil); err != nil {
+		return err, nil
+	}
+	heap := vm.CallStack.Current().GetHeap()
+
+	var env parser.Env
+	if err, env = vm.GetEnvironment(); err == nil && env != nil {
+		err = heap.Assign("env", env.GetValue().Value, true, true)
+	}
+
+	var result *data.Value
+	if err == nil {
+		err, result = program.Block.Eval(vm)
+	}
+	if returnErr, _ := vm.CallStack.Return(vm); returnErr != nil {
+		return returnErr, nil
+	}
+
+	if purposeful, ok := err.(errors.PurposefulError); ok {
+		switch purposeful {
+		case errors.Break:
+			return errors.BreakOutsideLoop.Errorf(vm), nil
+		case errors.Continue:
+			return errors.ContinueOutsideLoop.Errorf(vm), nil
+		case errors.Return:
+			// Returning from a module stops its evaluation early
+			err = nil
+		default:
+			// A thrown value is passed on to the importer, so that it can be caught
+			return err, result
+		}
+	}
+	if err != nil {
+		return err, nil
+	}
+	return nil, vm.Modules.store(abs, parser.Namespace(heap))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/alecthomas/participle/v2"
 	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/andygello555/data"
 	"github.com/andygello555/eval"
 	"strings"
 )
@@ -62,9 +63,38 @@ type Factor struct {
 	JSONPathFactor *JSONPathFactor `| @@`
 	FunctionCall   *FunctionCall   `| @@`
 	MethodCall     *MethodCall     `| @@`
+	Function       *Function       `| @@`
+	Unary          *Unary          `| @@`
+	Conditional    *Conditional    `| @@`
 	SubExpression  *Expression     `| "(" @@ ")"`
 }
 
+// Conditional describes a conditional expression. Only one of Then or Else is evaluated, depending on whether the
+// Condition is truthy.
+type Conditional struct {
+	Pos lexer.Position
+
+	Condition *Expression `"(" If @@ Then`
+	Then      *Expression `@@`
+	Else      *Expression `Else @@ ")"`
+}
+
+// Unary describes a Factor preceded by a unary operator. Unary operators bind tighter than any binary operator.
+type Unary struct {
+	Pos lexer.Position
+
+	Operator eval.UnaryOperator `@("!" | "-" | "+")`
+	Factor   *Factor            `@@`
+}
+
+// Function describes an anonymous function that can be used as a value within an expression. Each time it is evaluated
+// it creates a new closure over the variables of the current stack frame.
+type Function struct {
+	Pos lexer.Position
+
+	Body *FunctionBody `Function @@`
+}
+
 // JSONPathFactor acts similarly to JSONPath, in that it is the root of a JSONPath. However, on top of the root property
 // being able to be Part, it can also be a FunctionCall, a MethodCall, or a JSON literal.
 type JSONPathFactor struct {
@@ -90,8 +120,29 @@ type JSONPart struct {
 type StringLitPart struct {
 	Pos lexer.Position
 
-	StringLit *string  `@StringLit`
-	Indices   []*Index `@@*`
+	StringLit *StringLit `@@`
+	Indices   []*Index   `@@*`
+}
+
+// StringLit describes a string literal token. A string literal is made up of Segments of text, and of Expressions
+// that are interpolated into the text by surrounding them with "{{" and "}}". StringLit is parsed by its own Parse
+// method rather than by the grammar, so that each interpolated Expression is parsed at its position within the token.
+//
+// A raw string literal is surrounded by triple quotes, and can span multiple lines. Raw string literals are made up of
+// a single Segment of text, as neither escape sequences nor interpolations are processed within them.
+type StringLit struct {
+	Pos lexer.Position
+
+	Raw      bool
+	Segments []*StringSegment
+}
+
+// StringSegment is a single segment of a StringLit. It is either a run of Text, or an Interpolation.
+type StringSegment struct {
+	Pos lexer.Position
+
+	Text          string
+	Interpolation *Expression
 }
 
 type Prec1Term struct {
@@ -188,8 +239,18 @@ type Prec5 struct {
 type FunctionBody struct {
 	Pos lexer.Position
 
-	Parameters []*JSONPath `"(" ( @@ ( "," @@ )* )? ")"`
-	Block      *Block      `@@ End`
+	Parameters []*Parameter `"(" ( @@ ( "," @@ )* ( "," "..."`
+	Rest       *JSONPath    `@@ )? | "..." @@ )? ")"`
+	Block      *Block       `@@ End`
+}
+
+// Parameter describes a parameter of a function. If the parameter has a Default, then the Default is evaluated and
+// assigned to the parameter when no argument is given for it.
+type Parameter struct {
+	Pos lexer.Position
+
+	JSONPath *JSONPath   `@@`
+	Default  *Expression `( "=" @@ )?`
 }
 
 // MethodCall describes a call to a HTTP method.
@@ -204,8 +265,17 @@ type MethodCall struct {
 type FunctionCall struct {
 	Pos lexer.Position
 
-	JSONPath  *JSONPath     `"$" @@`
-	Arguments []*Expression `"(" (@@ ( "," @@ )*)? ")"`
+	JSONPath  *JSONPath   `"$" @@`
+	Arguments []*Argument `"(" (@@ ( "," @@ )*)? ")"`
+}
+
+// Argument describes an argument given to a FunctionCall. If the argument has a Name, then it is assigned to the
+// parameter of the same name rather than the parameter at its position.
+type Argument struct {
+	Pos lexer.Position
+
+	Name  *string     `( @Ident "=" )?`
+	Value *Expression `@@`
 }
 
 // ReturnStatement describes a return statement which can be at the end of any block.
@@ -257,11 +327,13 @@ type Statement struct {
 	FunctionCall       *FunctionCall       `| @@ ";"`
 	MethodCall         *MethodCall         `| @@ ";"`
 	Break              *string             `| @Break ";"`
+	Continue           *string             `| @Continue ";"`
 	Test               *TestStatement      `| @@`
 	While              *While              `| @@`
 	For                *For                `| @@`
 	ForEach            *ForEach            `| @@`
 	Batch              *Batch              `| @@`
+	Parallel           *Parallel           `| @@`
 	TryCatch           *TryCatch           `| @@`
 	FunctionDefinition *FunctionDefinition `| @@`
 	IfElifElse         *IfElifElse         `| @@`
@@ -303,21 +375,42 @@ type ForEach struct {
 	Block *Block      `@@ End`
 }
 
-// Batch describes a block of code where all HTTP method calls are executed in parallel.
+// Batch describes a block of code where all HTTP method calls are executed in parallel. The number of HTTP method
+// calls that can be executed at once can be given after "with", and the number of seconds that the HTTP method calls
+// have to finish can be given after "within". This can be followed by the policy for when a HTTP method call fails:
+// "fail fast" cancels every other HTTP method call, and "collect into" collects the results and errors of every HTTP
+// method call into the given variable.
 type Batch struct {
 	Pos lexer.Position
 
-	Block *Block `Batch This @@ End`
+	Workers  *Expression `Batch This (With @@)?`
+	Within   *Expression `(Within @@)?`
+	FailFast bool        `( @FailFast`
+	Collect  *string     `| Collect @Ident )?`
+	Block    *Block      `@@ End`
+}
+
+// Parallel describes a block of FunctionCalls that are all evaluated at the same time. Each Statement within the Block
+// must be a FunctionCall, or an Assignment of a lone FunctionCall. The number of FunctionCalls that can be evaluated at
+// once can be given after "with".
+type Parallel struct {
+	Pos lexer.Position
+
+	Workers *Expression `Parallel This (With @@)?`
+	Block   *Block      `@@ End`
 }
 
-// TryCatch describes a try-catch structure. The "as" segment must always be defined so a variable can be allocated with
-// the caught exception.
+// TryCatch describes a try-catch-finally structure. The "as" segment of the catch must always be defined so a variable
+// can be allocated with the caught exception. The catch can be given a list of error types and subsets which it is
+// limited to. Either the catch or the finally segment can be left out, but not both.
 type TryCatch struct {
 	Pos lexer.Position
 
-	Try     *Block  `Try This @@`
-	CatchAs *string `Catch As @Ident Do`
-	Caught  *Block  `@@ End`
+	Try     *Block   `Try This @@`
+	Filters []string `( Catch ( @Ident ( "," @Ident )* )?`
+	CatchAs *string  `As @Ident Do`
+	Caught  *Block   `@@`
+	Finally *Block   `( Finally Do @@ )? | Finally Do @@ ) End`
 }
 
 // FunctionDefinition describes the definition of function.
@@ -326,6 +419,13 @@ type FunctionDefinition struct {
 
 	JSONPath *JSONPath     `Function @@`
 	Body     *FunctionBody `@@`
+
+	// module is the Heap of the bottommost stack frame of the module that the function was imported from. This is nil
+	// if the function was not imported.
+	module *data.Heap
+	// closure is the Heap of the stack frame that an anonymous Function was evaluated within. This is nil if the
+	// function is not anonymous.
+	closure *data.Heap
 }
 
 // IfElifElse is the main construct which defines a if-elif-else statement.
@@ -363,8 +463,9 @@ type Program struct {
 }
 
 var Lex = lexer.MustSimple([]lexer.Rule{
-	{"comment", `//.*`, nil},
+	{"comment", `//.*|/\*(?s:.*?)\*/`, nil},
 
+	{"RawString", `"""(?s:.*?)"""`, nil},
 	{"StringLit", `(")([^"\\]*(?:\\.[^"\\]*)*)(")`, nil},
 	{"Method", fmt.Sprintf("(%s)", strings.Join(eval.MethodStrings(), "|")), nil},
 	{"While", `while\s`, nil},
@@ -372,38 +473,58 @@ var Lex = lexer.MustSimple([]lexer.Rule{
 	{"Do", `\sdo\s`, nil},
 	{"This", `this\s`, nil},
 	{"Break", `break`, nil},
+	{"Continue", `continue\b`, nil},
 	{"Then", `\?\s`, nil},
 	{"End", `end`, nil},
-	{"Function", `fun\s`, nil},
+	{"Function", `fun\b`, nil},
 	{"Return", `return`, nil},
 	{"Throw", `throw`, nil},
 	{"If", `is\s`, nil},
 	{"Elif", `elis\s`, nil},
 	{"Else", `else\s`, nil},
 	{"Catch", `catch\s`, nil},
+	{"Finally", `finally\b`, nil},
 	{"Test", `test\s`, nil},
 	{"In", `\sin\s`, nil},
 	{"As", `as\s`, nil},
+	{"With", `with\s`, nil},
+	{"Within", `within\s`, nil},
+	{"FailFast", `fail\s+fast\s`, nil},
+	{"Collect", `collect\s+into\s`, nil},
 	{"True", `true`, nil},
 	{"False", `false`, nil},
 	{"Null", `null`, nil},
 	{"Batch", `batch\s`, nil},
+	{"Parallel", `parallel\s`, nil},
 	{"Try", `try\s`, nil},
 	{"Number", `[-+]?(\d*\.)?\d+`, nil},
 	{"Operators", `\|\||&&|<=|>=|!=|==|[-+*/%=!<>]`, nil},
 	{"Filter", "```", nil},
-	{"Punct", `[$;,.(){}:]|\[|\]`, nil},
+	{"Punct", `\.\.\.|[$;,.(){}:]|\[|\]`, nil},
 	{"Ident", `[a-zA-Z_]\w*`, nil},
 	{"whitespace", `\s+`, nil},
 })
 
-func Parse(filename, s string) (error, *Program) {
+func Parse(filename, s string) (err error, program *Program) {
+	defer recoverStringLit(&err)
 	parser := participle.MustBuild(&Program{},
 		participle.Lexer(Lex),
 		participle.CaseInsensitive("Ident"),
-		participle.Unquote("StringLit"),
 		participle.UseLookahead(2),
 	)
-	program := &Program{}
+	program = &Program{}
 	return parser.ParseString(filename, s, program), program
 }
+
+// ParseJSONPath parses the given string as a standalone JSONPath. This is used by builtins which take JSONPaths as
+// strings.
+func ParseJSONPath(s string) (err error, jsonPath *JSONPath) {
+	defer recoverStringLit(&err)
+	parser := participle.MustBuild(&JSONPath{},
+		participle.Lexer(Lex),
+		participle.CaseInsensitive("Ident"),
+		participle.UseLookahead(2),
+	)
+	jsonPath = &JSONPath{}
+	return parser.ParseString("", s, jsonPath), jsonPath
+}
@@ -0,0 +1,275 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"github.com/andygello555/data"
+	"github.com/andygello555/errors"
+	"github.com/andygello555/eval"
+	"time"
+)
+
+// asyncBuiltin starts the lone MethodCall or FunctionCall given as its only argument without waiting for it to finish,
+// and returns a data.Future for its result.
+//
+// The arguments of a MethodCall are evaluated straight away, and the request is then made on the VM's shared pool of
+// workers. A FunctionCall is evaluated in its entirety on a VM forked from the given VM, so that it cannot race with
+// the caller over the variables on the current stack frame.
+//
+// The data.Future of a MethodCall can be cancelled using data.Cancel, which aborts the request. A FunctionCall cannot be
+// cancelled, so its forked VM carries on until the FunctionCall finishes, even if nothing awaits its result.
+func asyncBuiltin(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+	if len(uncomputedArgs) != 1 {
+		return errors.AsyncError.Errorf(vm, fmt.Sprintf("%d arguments", len(uncomputedArgs)), "a single method call or function call must be given"), nil
+	}
+
+	var promise data.Promise
+	switch call := loneArg(uncomputedArgs[0]).(type) {
+	case *MethodCall:
+		vm.SetPos(call.GetPos())
+		var args []*data.Value
+		if err, args = call.args(vm); err != nil {
+			return err, nil
+		}
+		promise = vm.Async(func(ctx context.Context) (err error, result *data.Value) {
+			return call.callContext(ctx, vm, args)
+		})
+	case *FunctionCall:
+		var fork VM
+		if err, fork = settledFork(vm); err != nil {
+			return err, nil
+		}
+		deferred := data.NewDeferred()
+		go func() {
+			deferred.Complete(call.Eval(fork))
+		}()
+		promise = deferred
+	default:
+		return errors.AsyncError.Errorf(vm, uncomputedArgs[0].String(0), "argument must be a method call or function call"), nil
+	}
+
+	if debug, ok := vm.GetDebug(); ok {
+		_, _ = fmt.Fprintf(debug, "started %s asynchronously\n", uncomputedArgs[0].String(0))
+	}
+	return nil, &data.Value{
+		Value: promise,
+		Type:  data.Future,
+	}
+}
+
+// settledFork forks the given VM. A forked VM is never within a Batch, so if the given VM is within a Batch then any
+// promises on its current stack frame are settled before they are copied.
+func settledFork(vm VM) (err error, fork VM) {
+	if vm.GetBatch() != nil {
+		for _, variable := range *vm.GetCallStack().Current().GetHeap() {
+			if err = resolveVariable(vm, variable); err != nil {
+				return err, nil
+			}
+		}
+	}
+	return nil, vm.Fork()
+}
+
+// awaitTimeout computes the optional timeout argument of $await and $await_all. The timeout is given in seconds. A
+// timeout of 0 is returned if the timeout is not given, or is null, which means that there is no timeout.
+func awaitTimeout(vm VM, args []*data.Value) (err error, timeout time.Duration) {
+	if len(args) < 2 || args[1].Type == data.Null {
+		return nil, 0
+	}
+
+	var seconds *data.Value
+	if err, seconds = eval.Cast(args[1], data.Number); err != nil {
+		return errors.UpdateError(err, vm), 0
+	}
+	if seconds.Value.(float64) <= 0 {
+		return errors.AwaitError.Errorf(vm, "with a timeout of "+seconds.String(), "timeout must be positive"), 0
+	}
+	return nil, time.Duration(seconds.Value.(float64) * float64(time.Second))
+}
+
+// awaitPromise awaits the given data.Promise for up to the given timeout. The name of the awaited value is used within
+// the AwaitError that is returned if the timeout elapses, in which case the data.Promise is also cancelled using
+// data.Cancel. Any error that the data.Promise settles to is returned along with the data.Value, so that values thrown
+// by FunctionCalls can be caught.
+func awaitPromise(vm VM, name string, promise data.Promise, timeout time.Duration) (err error, value *data.Value) {
+	var ok bool
+	if err, value, ok = data.AwaitWithin(promise, timeout); !ok {
+		data.Cancel(promise)
+		return errors.AwaitError.Errorf(vm, name, fmt.Sprintf("timed out after %s", timeout.String())), nil
+	} else if err != nil {
+		return errors.UpdateError(err, vm), value
+	}
+	if value == nil {
+		value = &data.Value{Type: data.Null}
+	}
+	return nil, value
+}
+
+// awaitBuiltin awaits the data.Future given as its first argument, and returns the value that it settled to. An
+// optional timeout, in seconds, can be given as the second argument. Values that are not Futures are returned as they
+// are.
+func awaitBuiltin(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+	if len(uncomputedArgs) == 0 {
+		return errors.AwaitError.Errorf(vm, "nothing", "a future must be given"), nil
+	}
+
+	var args []*data.Value
+	if err, args = computeArgs(vm, uncomputedArgs...); err != nil {
+		return err, nil
+	}
+
+	var timeout time.Duration
+	if err, timeout = awaitTimeout(vm, args); err != nil {
+		return err, nil
+	}
+
+	promise, ok := args[0].Value.(data.Promise)
+	if !ok {
+		return nil, args[0]
+	}
+	return awaitPromise(vm, uncomputedArgs[0].String(0), promise, timeout)
+}
+
+// awaitAllBuiltin awaits each data.Future within the Array given as its first argument, in order, and returns an Array
+// of the values that they settled to. An optional timeout, in seconds, can be given as the second argument. This is
+// the timeout for all the Futures, not for each one. The first error that is found is thrown. If the timeout elapses,
+// then every Future that has not yet settled is cancelled.
+func awaitAllBuiltin(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+	if len(uncomputedArgs) == 0 {
+		return errors.AwaitError.Errorf(vm, "nothing", "an array of futures must be given"), nil
+	}
+
+	var args []*data.Value
+	if err, args = computeArgs(vm, uncomputedArgs...); err != nil {
+		return err, nil
+	}
+	if args[0].Type != data.Array {
+		return errors.AwaitError.Errorf(vm, uncomputedArgs[0].String(0), "first argument must be an array of futures"), nil
+	}
+
+	var timeout time.Duration
+	if err, timeout = awaitTimeout(vm, args); err != nil {
+		return err, nil
+	}
+	deadline := time.Now().Add(timeout)
+
+	elements := args[0].Value.([]interface{})
+	settled := make([]interface{}, len(elements))
+	for i, element := range elements {
+		promise, ok := element.(data.Promise)
+		if !ok {
+			settled[i] = element
+			continue
+		}
+
+		remaining := timeout
+		if timeout > 0 {
+			// A Future that has already settled can still be awaited once the deadline has passed
+			if remaining = time.Until(deadline); remaining <= 0 {
+				remaining = time.Nanosecond
+			}
+		}
+
+		var result *data.Value
+		if err, result = awaitPromise(vm, fmt.Sprintf("%s[%d]", uncomputedArgs[0].String(0), i), promise, remaining); err != nil {
+			if timeout > 0 && time.Until(deadline) <= 0 {
+				for _, element := range elements[i+1:] {
+					if promise, ok := element.(data.Promise); ok {
+						data.Cancel(promise)
+					}
+				}
+			}
+			return err, result
+		}
+		settled[i] = result.Value
+	}
+	return nil, &data.Value{
+		Value: settled,
+		Type:  data.Array,
+	}
+}
+
+// HeapCopier deep copies the variables on a Heap for a forked VM. Unlike data.Copy, anonymous functions are also
+// copied, and are given a copy of the Heap that they close over. Each Heap and each data.Value is only copied once, so
+// functions that close over the same Heap, or variables that are shared by reference between Heaps, are still shared
+// within the copy.
+type HeapCopier struct {
+	heaps  map[*data.Heap]*data.Heap
+	values map[*data.Value]*data.Value
+}
+
+// NewHeapCopier creates a HeapCopier that has not copied anything yet.
+func NewHeapCopier() *HeapCopier {
+	return &HeapCopier{
+		heaps:  make(map[*data.Heap]*data.Heap),
+		values: make(map[*data.Value]*data.Value),
+	}
+}
+
+// CopyInto copies each variable on the from Heap onto the to Heap. Functions that close over the from Heap will close
+// over the to Heap instead.
+func (c *HeapCopier) CopyInto(from *data.Heap, to *data.Heap) {
+	c.heaps[from] = to
+	for name, variable := range *from {
+		(*to)[name] = c.Value(variable)
+	}
+}
+
+// Heap returns the copy of the given Heap, copying it if it has not been copied already.
+func (c *HeapCopier) Heap(heap *data.Heap) *data.Heap {
+	if copied, ok := c.heaps[heap]; ok {
+		return copied
+	}
+	copied := make(data.Heap, len(*heap))
+	c.CopyInto(heap, &copied)
+	return &copied
+}
+
+// Value returns the copy of the given data.Value, copying it if it has not been copied already.
+func (c *HeapCopier) Value(value *data.Value) *data.Value {
+	if copied, ok := c.values[value]; ok {
+		return copied
+	}
+	copied := &data.Value{
+		Type:     value.Type,
+		Global:   value.Global,
+		ReadOnly: value.ReadOnly,
+	}
+	// The copy is recorded before its value is copied, as the value can contain a function that closes over a Heap
+	// that contains the value
+	c.values[value] = copied
+	copied.Value = c.copy(value.Value)
+	return copied
+}
+
+// copy deep copies the given value in the same way as data.Copy, but also copies anonymous functions.
+func (c *HeapCopier) copy(value interface{}) interface{} {
+	switch value.(type) {
+	case map[string]interface{}:
+		obj := make(map[string]interface{}, len(value.(map[string]interface{})))
+		for key, elem := range value.(map[string]interface{}) {
+			obj[key] = c.copy(elem)
+		}
+		return obj
+	case []interface{}:
+		arr := make([]interface{}, len(value.([]interface{})))
+		for i, elem := range value.([]interface{}) {
+			arr[i] = c.copy(elem)
+		}
+		return arr
+	case *FunctionDefinition:
+		function := value.(*FunctionDefinition)
+		if function.closure == nil {
+			return function
+		}
+		return &FunctionDefinition{
+			Pos:      function.Pos,
+			JSONPath: function.JSONPath,
+			Body:     function.Body,
+			module:   function.module,
+			closure:  c.Heap(function.closure),
+		}
+	default:
+		return value
+	}
+}
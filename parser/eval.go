@@ -1,13 +1,16 @@
 package parser
 
 import (
-	"container/heap"
+	"context"
 	"fmt"
 	"github.com/andygello555/data"
 	"github.com/andygello555/errors"
 	"github.com/andygello555/eval"
+	"io"
 	"reflect"
 	"strings"
+	"sync"
+	"time"
 )
 
 type evalNode interface {
@@ -29,7 +32,7 @@ func (p *Program) Eval(vm VM) (err error, result *data.Value) {
 	// We insert a nil stack frame to indicate the bottom of the stack. We check if stack size is zero because if the
 	// VM is in REPL mode, we do not want to add another bottommost stack frame onto of the original.
 	if vm.GetCallStack().Size() == 0 {
-		err = vm.GetCallStack().Call(nil, nil, vm)
+		err = vm.GetCallStack().Call(nil, nil, vm, nil)
 	}
 
 	// We insert the environment (if we have one)
@@ -55,6 +58,11 @@ func (p *Program) Eval(vm VM) (err error, result *data.Value) {
 		}
 	}
 
+	// Check the Program before evaluating it
+	if err = p.Validate(vm); err != nil {
+		return err, nil
+	}
+
 	// Evaluate the inner Block
 	if err, result = p.Block.Eval(vm); err == nil {
 		if debug, ok := vm.GetDebug(); ok {
@@ -71,6 +79,8 @@ func (p *Program) Eval(vm VM) (err error, result *data.Value) {
 		case errors.Break:
 			// Exchange the error for a more informative one
 			err = errors.BreakOutsideLoop.Errorf(vm)
+		case errors.Continue:
+			err = errors.ContinueOutsideLoop.Errorf(vm)
 		case errors.Throw:
 			// Wrap the user error within a go error
 			errVal, _ := errors.ConstructSttpError(err, result.Value)
@@ -85,6 +95,57 @@ func (p *Program) Eval(vm VM) (err error, result *data.Value) {
 	return err, result
 }
 
+// Validate checks the Program for errors that can be found before it is evaluated, so that the Program is not left
+// partially evaluated. Currently, this only returns an errors.ContinueOutsideLoop if the Program contains a continue
+// statement that is not within a loop.
+func (p *Program) Validate(vm VM) error {
+	if stmt := continueOutsideLoop(reflect.ValueOf(p.Block), false); stmt != nil {
+		vm.SetPos(stmt.GetPos())
+		return errors.ContinueOutsideLoop.Errorf(vm)
+	}
+	return nil
+}
+
+// continueOutsideLoop walks the given AST node and returns the first continue Statement within it that is not within a
+// loop. inLoop is set when the node is within a loop. The body of a function is not within the loops that surround it,
+// as a continue statement cannot leave a function.
+func continueOutsideLoop(node reflect.Value, inLoop bool) *Statement {
+	switch node.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if node.IsNil() {
+			return nil
+		}
+		switch n := node.Interface().(type) {
+		case *Statement:
+			if n.Continue != nil && !inLoop {
+				return n
+			}
+		case *While, *For, *ForEach:
+			inLoop = true
+		case *FunctionBody:
+			inLoop = false
+		}
+		return continueOutsideLoop(node.Elem(), inLoop)
+	case reflect.Struct:
+		for i := 0; i < node.NumField(); i++ {
+			// Unexported fields, such as the closure of a FunctionDefinition, are not part of the AST
+			if node.Type().Field(i).PkgPath != "" {
+				continue
+			}
+			if stmt := continueOutsideLoop(node.Field(i), inLoop); stmt != nil {
+				return stmt
+			}
+		}
+	case reflect.Slice:
+		for i := 0; i < node.Len(); i++ {
+			if stmt := continueOutsideLoop(node.Index(i), inLoop); stmt != nil {
+				return stmt
+			}
+		}
+	}
+	return nil
+}
+
 // Eval for Block will evaluate each Statement within it. A Block can end with either a ReturnStatement or a
 // ThrowStatement. If either exist they will also be evaluated and returned before the Statements are returned.
 func (b *Block) Eval(vm VM) (err error, result *data.Value) {
@@ -167,9 +228,16 @@ func (s *Statement) Eval(vm VM) (err error, result *data.Value) {
 	case s.FunctionCall != nil:
 		err, result = s.FunctionCall.Eval(vm)
 	case s.MethodCall != nil:
-		err, result = s.MethodCall.Eval(vm)
+		// The result of a MethodCall statement is never read, so within a Batch there is no need to wait for it
+		if vm.GetBatch() != nil {
+			err, result = s.MethodCall.Promise(vm)
+		} else {
+			err, result = s.MethodCall.Eval(vm)
+		}
 	case s.Break != nil:
 		return errors.Break, nil
+	case s.Continue != nil:
+		return errors.Continue, nil
 	case s.Test != nil:
 		err, result = s.Test.Eval(vm)
 	case s.While != nil:
@@ -180,6 +248,8 @@ func (s *Statement) Eval(vm VM) (err error, result *data.Value) {
 		err, result = s.ForEach.Eval(vm)
 	case s.Batch != nil:
 		err, result = s.Batch.Eval(vm)
+	case s.Parallel != nil:
+		err, result = s.Parallel.Eval(vm)
 	case s.TryCatch != nil:
 		err, result = s.TryCatch.Eval(vm)
 	case s.FunctionDefinition != nil:
@@ -198,10 +268,22 @@ func (s *Statement) Eval(vm VM) (err error, result *data.Value) {
 //    property's value is not found, we default to data.Null.
 //
 // 2. The RHS (Value) of the Assignment is then evaluated. If the RHS is a data.Function, then we will create a copy of
-//    the FunctionDefinition, changing the JSONPath to the JSONPath of the Assignment.
+//    the FunctionDefinition, changing the JSONPath to the JSONPath of the Assignment. If we are within a Batch and the
+//    RHS is a lone MethodCall, then the RHS is evaluated to a data.Promise for the result of the MethodCall instead.
 //
 // 3. We then set this evaluated value on the RHS using the Path we converted earlier.
 func (a *Assignment) Eval(vm VM) (err error, result *data.Value) {
+	return a.assign(vm, func() (err error, result *data.Value) {
+		if methodCall := methodCallArg(a.Value); methodCall != nil && vm.GetBatch() != nil {
+			return methodCall.Promise(vm)
+		}
+		return a.Value.Eval(vm)
+	})
+}
+
+// assign carries out the steps of Assignment.Eval, but evaluates the RHS by calling the given function. This is so
+// that the RHS can be evaluated elsewhere, such as by a Parallel statement.
+func (a *Assignment) assign(vm VM, rhs func() (err error, result *data.Value)) (err error, result *data.Value) {
 	vm.SetPos(a.GetPos())
 	// Then we convert the JSONPath to a Path representation which can be easily iterated over.
 	var path Path
@@ -225,14 +307,21 @@ func (a *Assignment) Eval(vm VM) (err error, result *data.Value) {
 		}
 	}
 
+	// If the Path descends into the variable then any Promises along the Path need to be resolved before it can be set
+	if len(path) > 1 {
+		if err = resolvePath(vm, variableVal, path); err != nil {
+			return err, nil
+		}
+	}
+
 	// Evaluate the RHS
-	err, result = a.Value.Eval(vm)
-	if err != nil {
+	if err, result = rhs(); err != nil {
 		return err, nil
 	}
 
 	// If the value we are setting to is a Function, then we will create a new *FunctionDefinition. This will be
-	// composed of the body of the function and the JSONPath of the variable that we are setting.
+	// composed of the body of the function and the JSONPath of the variable that we are setting. The module and the
+	// closure of the function are kept.
 	if result.Type == data.Function {
 		// This will only create a new pointer to store the function pointer in
 		oldFunction := result.Value.(*FunctionDefinition)
@@ -241,6 +330,8 @@ func (a *Assignment) Eval(vm VM) (err error, result *data.Value) {
 			Pos:      oldFunction.Pos,
 			JSONPath: a.JSONPath,
 			Body:     oldFunction.Body,
+			module:   oldFunction.module,
+			closure:  oldFunction.closure,
 		}
 		// Finally, we set the result.Value to be the newFunction that we have just created
 		result.Value = newFunction
@@ -259,65 +350,82 @@ func (a *Assignment) Eval(vm VM) (err error, result *data.Value) {
 		return errors.UpdateError(err, vm), nil
 	}
 
+	// The variable might be shared with other stack frames, so the Batch needs to settle it once it has finished
+	if batch := vm.GetBatch(); batch != nil {
+		batch.Assigned(hp.Get(variableName))
+	}
+
 	if debug, ok := vm.GetDebug(); ok {
 		_, _ = fmt.Fprintf(debug, "after assignment of %s heap is: %v global: %t scope: %d\n", a.JSONPath.String(0), hp, hp.Get(variableName).Global, *vm.GetScope())
 	}
 	return nil, nil
 }
 
-// Eval for MethodCall will first evaluate all Arguments given to it. Then, depending on whether we are currently within
-// Batch and in our first pass, currently within a Batch and in the second pass, or not in a Batch at all.
-//
-// First pass of Batch: add the MethodCall as work to the work queue.
-//
-// Second pass of Batch: pop the next result in the BatchSuite's result queue. If there isn't a result to pop then
-// return an errors.MethodCallMismatchInBatch. If there is one but the pointer does not point to the current MethodCall,
-// then also errors.MethodCallMismatchInBatch. Otherwise, the result and error from the freshly popped result will be
-// returned.
-//
-// Not in Batch: returns the synchronously evaluated MethodCall.
-func (m *MethodCall) Eval(vm VM) (err error, result *data.Value) {
-	vm.SetPos(m.GetPos())
-	args := make([]*data.Value, len(m.Arguments))
+// args evaluates the Arguments of the MethodCall.
+func (m *MethodCall) args(vm VM) (err error, args []*data.Value) {
+	args = make([]*data.Value, len(m.Arguments))
 	for i, arg := range m.Arguments {
 		if err, args[i] = arg.Eval(vm); err != nil {
 			return err, nil
 		}
 	}
+	return nil, args
+}
 
-	if batch, results := vm.GetBatch(); batch != nil && results == nil {
-		// If we are currently batching MethodCalls then we will add the MethodCall to the vm.Batch and return null.
-		batch.AddWork(m, args...)
-		if debug, ok := vm.GetDebug(); ok {
-			_, _ = fmt.Fprintf(debug, "adding %s %v to work queue\n", m.String(0), args)
-		}
-		return nil, &data.Value{
-			Value: nil,
-			Type:  data.Null,
-		}
-	} else if batch != nil && results != nil {
-		if results.Len() > 0 {
-			// If we have batched results available, aka. the batch has been executed, then we will pop the next result and
-			// return its error and data.Value.
-			r := heap.Pop(results).(BatchResult)
-			// If the current result's MethodCall pointer does not match the pointer to the current MethodCall then we will
-			// return the appropriate error.
-			if debug, ok := vm.GetDebug(); ok {
-				_, _ = fmt.Fprintf(debug, "popped %s from result queue\n", r.GetMethodCall().String(0))
-			}
-			if r.GetMethodCall() != m {
-				return errors.MethodCallMismatchInBatch.Errorf(vm, r.GetMethodCall().String(0), m.String(0)), nil
-			}
-			return errors.UpdateError(r.GetErr(), vm), r.GetValue()
-		} else {
-			// If we have not got anymore results then we have a mismatch of batched MethodCalls.
-			return errors.MethodCallMismatchInBatch.Errorf(vm, "null (no more results)", m.String(0)), nil
+// Promise will first evaluate all Arguments given to the MethodCall. Then the MethodCall is added as work to the
+// BatchSuite of the VM, and a data.Value containing the data.Promise for its result is returned. This should only be
+// called when the VM is within a Batch.
+func (m *MethodCall) Promise(vm VM) (err error, result *data.Value) {
+	vm.SetPos(m.GetPos())
+	var args []*data.Value
+	if err, args = m.args(vm); err != nil {
+		return err, nil
+	}
+
+	promise := vm.GetBatch().AddWork(m, args...)
+	if debug, ok := vm.GetDebug(); ok {
+		_, _ = fmt.Fprintf(debug, "adding %s %v to work queue\n", m.String(0), args)
+	}
+	return nil, &data.Value{
+		Value: promise,
+		Type:  data.Future,
+	}
+}
+
+// Eval for MethodCall will first evaluate all Arguments given to it. If we are currently within a Batch then the
+// MethodCall will be added as work to the BatchSuite, and then its result will be awaited straight away. Otherwise, the
+// MethodCall is evaluated synchronously.
+func (m *MethodCall) Eval(vm VM) (err error, result *data.Value) {
+	if vm.GetBatch() != nil {
+		if err, result = m.Promise(vm); err != nil {
+			return err, nil
 		}
-	} else {
-		// Otherwise, we are just executing the MethodCall normally.
-		err, result = m.Method.Call(args...)
+		err, result = result.Value.(data.Promise).Await()
+		vm.SetPos(m.GetPos())
 		return errors.UpdateError(err, vm), result
 	}
+
+	vm.SetPos(m.GetPos())
+	var args []*data.Value
+	if err, args = m.args(vm); err != nil {
+		return err, nil
+	}
+	err, result = m.call(vm, args)
+	return errors.UpdateError(err, vm), result
+}
+
+// call makes the MethodCall with the given computed arguments using the VM's eval.Client, then records how long it
+// took using VM.RecordCall.
+func (m *MethodCall) call(vm VM, args []*data.Value) (err error, result *data.Value) {
+	return m.callContext(context.Background(), vm, args)
+}
+
+// callContext is the same as call, but the request is made using the given context.Context.
+func (m *MethodCall) callContext(ctx context.Context, vm VM, args []*data.Value) (err error, result *data.Value) {
+	start := time.Now()
+	err, result = m.Method.CallContext(ctx, vm.GetClient(), args...)
+	vm.RecordCall(m, time.Since(start), err)
+	return err, result
 }
 
 // Eval for TestStatement will first check if there are TestResults defined within the VM, if not then fresh TestResults
@@ -396,7 +504,11 @@ func (w *While) Eval(vm VM) (err error, result *data.Value) {
 	// Then we execute the while loop
 	for evalCond() {
 		if err, _ = w.Block.Eval(vm); err != nil {
-			panic(err)
+			// A continue statement skips straight to the next iteration
+			if err != errors.Continue {
+				panic(err)
+			}
+			err = nil
 		}
 	}
 	return err, nil
@@ -453,7 +565,11 @@ func (f *For) Eval(vm VM) (err error, result *data.Value) {
 	// Then we do our loop
 	for evalCond() {
 		if err, _ = f.Block.Eval(vm); err != nil {
-			panic(err)
+			// A continue statement skips straight to the step of the next iteration
+			if err != errors.Continue {
+				panic(err)
+			}
+			err = nil
 		}
 		evalStep()
 	}
@@ -462,9 +578,10 @@ func (f *For) Eval(vm VM) (err error, result *data.Value) {
 }
 
 // Eval for ForEach loop. Will iterate over each value in the In value. If the In value is not a data.String,
-// data.Object, or data.Array, then we will first try to eval.Cast In into a data.String, then a data.Object, and
-// finally data.Array. If we cannot cast In to any of these, we will return an errors.CannotCast error. A data.Iterator
-// will then be constructed to iterate over the values in the In value.
+// data.Object, data.Array, or data.Iterable, then we will first try to eval.Cast In into a data.String, then a
+// data.Object, and finally data.Array. If we cannot cast In to any of these, we will return an errors.CannotCast error.
+// A data.Iterator will then be constructed to iterate over the values in the In value. The values of a data.Iterable
+// are only generated as each iteration starts.
 func (f *ForEach) Eval(vm VM) (err error, result *data.Value) {
 	vm.SetPos(f.GetPos())
 	// Find the value we are iterating over
@@ -477,7 +594,7 @@ func (f *ForEach) Eval(vm VM) (err error, result *data.Value) {
 	// - String
 	// - Object
 	// - Array
-	if result.Type != data.String && result.Type != data.Object && result.Type != data.Array {
+	if result.Type != data.String && result.Type != data.Object && result.Type != data.Array && result.Type != data.Iterable {
 		// Find out what we can cast the value to
 		var to data.Type
 		if eval.Castable(result, data.String) {
@@ -539,146 +656,329 @@ func (f *ForEach) Eval(vm VM) (err error, result *data.Value) {
 		//set(heap.Pop(iterator).(*data.Element))
 		set(iterator.Next())
 		if err, result = f.Block.Eval(vm); err != nil {
-			panic(err)
+			// A continue statement skips straight to the next iteration
+			if err != errors.Continue {
+				panic(err)
+			}
+			err = nil
 		}
 	}
+
+	// Generating the next value of a data.Iterable can fail
+	if err = iterator.Err(); err != nil {
+		panic(err)
+	}
 	return err, nil
 }
 
 // Eval for Batch follows the following set of steps:
 //
-// 1. If there is a BatchSuite or results for the BatchSuite set up already then we will assume that there is a Batch
-//    within a Batch. This means we will return an errors.BatchWithinBatch.
+// 1. If there is a BatchSuite set up already then we will assume that there is a Batch within a Batch. This means we
+//    will return an errors.BatchWithinBatch.
 //
-// 2. We cache the stdout and stderr file handlers, and set the interpreter to use temporary string buffers instead.
-//    Then we create a deep copy of the current Frame's data.Heap, and set this copy as the new data.Heap for the
-//    current Frame.
+// 2. If the Batch has a Workers expression then it is evaluated, otherwise the VM's default number of workers is used.
+//    If the Batch has a Within expression then it is evaluated as the number of seconds that the MethodCalls have to
+//    finish. A BatchSuite is created, and its workers are started.
 //
-// 3. A BatchSuite is created, and its workers are started. The first pass of the Block is then initiated.
+// 3. The Block is evaluated once. Each MethodCall that is assigned to a variable, or whose result is discarded, is
+//    added as work to the BatchSuite and evaluates to a data.Promise. Reading a variable that contains a data.Promise
+//    will wait for the data.Promise to settle. Any other MethodCall is added as work and awaited straight away.
 //
-// 4. After this succeeds, we set the stdout and stderr file handlers to the ones cached before the first pass was
-//    initiated. We also wait for the BatchSuite to execute all the work it was given just now.
+// 4. We wait for the BatchSuite to execute all the work that it was given, and then every data.Promise left on the
+//    current Frame's data.Heap is replaced by the value it settled to. Finally, the BatchSuite is deleted.
 //
-// 5. If the BatchSuite has not executed any work (aka. there were no MethodCall(s) within the Batch) we will write the
-//    temporary stdout and stderr to the cached stdout and stderr and set them back as the defaults. This effectively
-//    just skips the second pass as it is unnecessary.
-//
-// 6. Otherwise, we will set the heap back to the old one, and then execute the second pass of the Block. If we still
-//    have results in the BatchSuite result queue after executing the second pass, we will return an
-//    errors.MethodCallMismatchInBatch.
-//
-// If an error occurs at any point in these steps, we will first set the stdout and stderr back to the cached ones, if
-// we haven't done already. We will also delete the BatchSuite, so that the interpreter knows to not Batch anymore.
+// If the Block returns an error then that error is returned. Otherwise, if a MethodCall failed but its data.Promise was
+// never read, the error of the first such MethodCall is returned. A Block that is left using a break or continue
+// statement still collects the results of its MethodCalls.
 func (b *Batch) Eval(vm VM) (err error, result *data.Value) {
 	vm.SetPos(b.GetPos())
-	batch, results := vm.GetBatch()
-	if batch == nil && results == nil {
-		// Replace Stdout and Stderr with temporary string buffers
-		oldStdout, oldStderr := vm.GetStdout(), vm.GetStderr()
-		var newStdout, newStderr strings.Builder
-		vm.SetStdout(&newStdout)
-		vm.SetStderr(&newStderr)
-
-		// Create a copy of the current heap
-		newHeap := make(data.Heap)
-		oldHeap := vm.GetCallStack().Current().GetHeap()
-		for name, val := range *oldHeap {
-			newHeap[name] = &data.Value{
-				Value:    val.Value,
-				Type:     val.Type,
-				Global:   val.Global,
-				ReadOnly: val.ReadOnly,
+	if vm.GetBatch() != nil {
+		// We return an error if we are already in a Batch statement
+		return errors.BatchWithinBatch.Errorf(vm), nil
+	}
+
+	// The number of workers is evaluated before anything is set up, so that there is nothing to tear down if it fails
+	workers := 0
+	if b.Workers != nil {
+		var w *data.Value
+		if err, w = b.Workers.Eval(vm); err != nil {
+			return err, nil
+		}
+		if w.Type != data.Number {
+			if err, w = eval.Cast(w, data.Number); err != nil {
+				return errors.UpdateError(err, vm), nil
 			}
 		}
-		// Use the copy as the new heap
-		*vm.GetCallStack().Current().GetHeap() = newHeap
-		// Set up the BatchSuite. vm.Batch is now not nil, but vm.BatchResults is...
-		vm.CreateBatch(b)
-		// Start the BatchSuite workers...
-		vm.StartBatch()
-
-		// Evaluate the Block for the first time. This will enqueue work to the worker goroutines running within the
-		// BatchSuite.
-		if err, result = b.Block.Eval(vm); err != nil {
-			// We also have to set the stdout and stderr back to their originals as well as stopping and deleting the
-			// batch altogether
-			vm.SetStdout(oldStdout)
-			vm.SetStderr(oldStderr)
-			vm.DeleteBatch()
+		if workers = w.Int(); workers < 1 {
+			return errors.InvalidBatchWorkers.Errorf(vm, w.String()), nil
+		}
+		vm.SetPos(b.GetPos())
+	}
+
+	// The deadline is given in seconds, and is also evaluated before anything is set up
+	var timeout time.Duration
+	if b.Within != nil {
+		var within *data.Value
+		if err, within = b.Within.Eval(vm); err != nil {
 			return err, nil
 		}
+		if within.Type != data.Number {
+			if err, within = eval.Cast(within, data.Number); err != nil {
+				return errors.UpdateError(err, vm), nil
+			}
+		}
+		vm.SetPos(b.GetPos())
+		if within.Value.(float64) <= 0 {
+			return errors.InvalidBatchDeadline.Errorf(vm, within.String()), nil
+		}
+		timeout = time.Duration(within.Value.(float64) * float64(time.Second))
+	}
 
-		// Then we set the stdout and stderr back to the old ones.
-		vm.SetStdout(oldStdout)
-		vm.SetStderr(oldStderr)
-		// Then we wait for all the work to be processed by stopping the BatchSuite...
-		vm.StopBatch()
+	// Set up the BatchSuite and start its workers. vm.Batch is now not nil...
+	vm.CreateBatch(b)
+	if timeout > 0 {
+		vm.GetBatch().Within(timeout)
+	}
+	vm.StartBatch(workers)
+
+	// Evaluate the Block. This will enqueue work to the worker goroutines running within the BatchSuite.
+	err, result = b.Block.Eval(vm)
+
+	// Then we wait for all the work to be processed, even if the Block failed, so that no Promises are left unsettled.
+	unread := vm.StopBatch()
+	heap := vm.GetCallStack().Current().GetHeap()
+	heap.Settle()
+	vm.GetBatch().Settle()
+	var collected []interface{}
+	if b.Collect != nil {
+		collected = collectBatch(vm, vm.GetBatch())
+	}
+	// Finally, we delete the Batch, this will set vm.Batch back to nil.
+	vm.DeleteBatch()
 
-		// We find the number of results that have been processed. If nothing has been processed, then we optimise by
-		// not running the Block again. We also keep the current copied over heap.
-		_, results = vm.GetBatch()
-		work := results.Len()
+	// Leaving the Block using a break or continue statement still collects the results of the MethodCalls
+	loopControl := err == errors.Break || err == errors.Continue
+	if err != nil && !loopControl {
+		return err, result
+	}
+	if b.Collect != nil {
+		vm.SetPos(b.GetPos())
+		if err := heap.Assign(*b.Collect, collected, false, false); err != nil {
+			return errors.UpdateError(err, vm), nil
+		}
+	}
+	if loopControl {
+		return err, result
+	}
+	if unread != nil {
+		vm.SetPos(b.GetPos())
+		return errors.UpdateError(unread, vm), nil
+	}
+	return nil, nil
+}
 
-		if work > 0 {
-			// We set the current heap back to the old one...
-			*vm.GetCallStack().Current().GetHeap() = *oldHeap
-			// Then we evaluate the Block again...
-			if err, result = b.Block.Eval(vm); err != nil {
-				vm.DeleteBatch()
-				return err, nil
-			}
+// collectBatch constructs an Object for the result of each MethodCall that was enqueued into the given BatchSuite. Each
+// Object contains the MethodCall as a String, the response of the MethodCall, and the error that the MethodCall
+// failed with. The error is constructed in the same way as errors caught by a TryCatch, and has the position of the
+// MethodCall.
+func collectBatch(vm VM, batch BatchSuite) []interface{} {
+	results := batch.Collect()
+	collected := make([]interface{}, len(results))
+	for i, result := range results {
+		var response, errVal interface{}
+		if err := result.GetErr(); err != nil {
+			vm.SetPos(result.GetMethodCall().GetPos())
+			errVal, _ = errors.ConstructSttpError(errors.UpdateError(err, vm), nil)
+		} else if value := result.GetValue(); value != nil {
+			response = value.Value
+		}
+		collected[i] = map[string]interface{}{
+			"call":     result.GetMethodCall().String(0),
+			"response": response,
+			"error":    errVal,
+		}
+	}
+	return collected
+}
+
+// parallelCall is a FunctionCall within a Parallel statement, along with the Assignment that its result is assigned by
+// and the output and result of its evaluation.
+type parallelCall struct {
+	call       *FunctionCall
+	assignment *Assignment
+	fork       VM
+	stdout     strings.Builder
+	stderr     strings.Builder
+	err        error
+	result     *data.Value
+}
+
+// Eval for Parallel. Will execute the following steps:
+//
+// 1. Each Statement within the Block is checked to be a FunctionCall, or an Assignment of a lone FunctionCall. If not,
+//    then an errors.ParallelError is returned before anything is evaluated.
+//
+// 2. If the Parallel has a Workers expression then it is evaluated, otherwise every FunctionCall is evaluated at once.
+//
+// 3. A VM is forked for each FunctionCall before any of them are evaluated, so that each FunctionCall sees the same
+//    copy of the current Frame's data.Heap. Each FunctionCall is then evaluated on its forked VM, with its stdout and
+//    stderr captured.
+//
+// 4. Once every FunctionCall has finished, the captured stdout and stderr of each FunctionCall is written, in the order
+//    that the FunctionCalls appear within the Block. Then, in the same order, the result of each FunctionCall that did
+//    not fail is assigned if it is part of an Assignment.
+//
+// The error of the first FunctionCall that failed, in the order that they appear within the Block, is returned.
+func (p *Parallel) Eval(vm VM) (err error, result *data.Value) {
+	vm.SetPos(p.GetPos())
+	if p.Block.Return != nil || p.Block.Throw != nil {
+		return errors.ParallelError.Errorf(vm, "return or throw statement", "only function calls can be run in parallel"), nil
+	}
+
+	calls := make([]*parallelCall, len(p.Block.Statements))
+	for i, statement := range p.Block.Statements {
+		calls[i] = &parallelCall{}
+		switch {
+		case statement.FunctionCall != nil:
+			calls[i].call = statement.FunctionCall
+		case statement.Assignment != nil:
+			calls[i].call, _ = loneArg(statement.Assignment.Value).(*FunctionCall)
+			calls[i].assignment = statement.Assignment
+		}
+		if calls[i].call == nil {
+			vm.SetPos(statement.GetPos())
+			return errors.ParallelError.Errorf(vm, strings.TrimSuffix(statement.String(0), ";\n"), "only function calls can be run in parallel"), nil
+		}
+	}
 
-			// If we still have results in the Batch that we haven't attached to a MethodCall. Then we will throw an
-			// error.
-			if _, results = vm.GetBatch(); results.Len() > 0 {
-				vm.DeleteBatch()
-				return errors.MethodCallMismatchInBatch.Errorf(vm, "null (too many results)", "null"), nil
+	workers := len(calls)
+	if p.Workers != nil {
+		var w *data.Value
+		if err, w = p.Workers.Eval(vm); err != nil {
+			return err, nil
+		}
+		if w.Type != data.Number {
+			if err, w = eval.Cast(w, data.Number); err != nil {
+				return errors.UpdateError(err, vm), nil
 			}
-		} else {
-			// If we have no work then we will write the new stdout and stderr into their respective io.Writers
-			_, _ = fmt.Fprint(vm.GetStdout(), newStdout.String())
-			_, _ = fmt.Fprint(vm.GetStderr(), newStderr.String())
 		}
+		vm.SetPos(p.GetPos())
+		if workers = w.Int(); workers < 1 {
+			return errors.ParallelError.Errorf(vm, "with "+w.String()+" workers", "there must be at least 1 worker"), nil
+		}
+	}
+
+	for _, call := range calls {
+		if err, call.fork = settledFork(vm); err != nil {
+			return err, nil
+		}
+		call.fork.SetStdout(&call.stdout)
+		call.fork.SetStderr(&call.stderr)
+	}
+
+	if debug, ok := vm.GetDebug(); ok {
+		_, _ = fmt.Fprintf(debug, "running %d function calls in parallel with %d workers\n", len(calls), workers)
+	}
 
-		// Finally, we delete the Batch, this will set both vm.Batch and vm.BatchResults back to nil.
-		vm.DeleteBatch()
-		return nil, nil
+	slots := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for _, call := range calls {
+		wg.Add(1)
+		go func(call *parallelCall) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			call.err, call.result = call.call.Eval(call.fork)
+		}(call)
 	}
-	// We return an error if we are already in a Batch statement
-	return errors.BatchWithinBatch.Errorf(vm), nil
+	wg.Wait()
+
+	stdout, stderr := vm.GetStdout(), vm.GetStderr()
+	for _, call := range calls {
+		_, _ = io.WriteString(stdout, call.stdout.String())
+		_, _ = io.WriteString(stderr, call.stderr.String())
+	}
+
+	for _, call := range calls {
+		if call.err != nil {
+			if err == nil {
+				err, result = call.err, call.result
+			}
+			continue
+		}
+		if call.assignment != nil {
+			value := call.result
+			if value == nil {
+				value = &data.Value{Type: data.Null}
+			}
+			if assignErr, _ := call.assignment.assign(vm, func() (err error, result *data.Value) { return nil, value }); assignErr != nil && err == nil {
+				err = assignErr
+			}
+		}
+	}
+	return err, result
 }
 
 // Eval for TryCatch will first execute the Block pointed to by the Try field. If Try returns an error then we will
 // check if the error is user constructed by testing if the result returned by Try is not nil. If so we will construct
-// a user defined error, otherwise we will construct a sttp error. This error will then be placed on the current heap
-// as the CatchAs identifier. The Caught Block will then be executed.
+// a user defined error, otherwise we will construct a sttp error. If the TryCatch has a catch that catches this error,
+// then the error will be placed on the current heap as the CatchAs identifier and the Caught Block will be executed.
+// Otherwise, the error is propagated.
+//
+// Finally, the Finally Block is executed regardless of whether an error occurred. If the Finally Block returns an error
+// then it replaces any error that is being propagated.
 func (tc *TryCatch) Eval(vm VM) (err error, result *data.Value) {
 	vm.SetPos(tc.GetPos())
-	if err, result = tc.Try.Eval(vm); err != nil {
+	if err, result = tc.Try.Eval(vm); err != nil && tc.CatchAs != nil {
 		// Check if the error is user constructed
 		var userErr interface{} = nil
 		if result != nil {
 			userErr = result.Value
 		}
 		// Construct the sttp error using the given err and or userErr
-		errVal, ret := errors.ConstructSttpError(err, userErr)
-		if ret {
-			return err, result
+		if errVal, ret := errors.ConstructSttpError(err, userErr); !ret && tc.catches(errVal) {
+			// Place the exception on the heap with the provided identifier
+			if err = vm.GetCallStack().Current().GetHeap().Assign(*tc.CatchAs, errVal, false, false); err == nil {
+				// Execute the catch block
+				err, result = tc.Caught.Eval(vm)
+			}
 		}
+	}
 
-		// Place the exception on the heap with the provided identifier
-		if err = vm.GetCallStack().Current().GetHeap().Assign(*tc.CatchAs, errVal, false, false); err != nil {
-			return err, nil
+	if tc.Finally != nil {
+		if finallyErr, finallyResult := tc.Finally.Eval(vm); finallyErr != nil {
+			return finallyErr, finallyResult
 		}
+	}
 
-		// Execute the catch block
-		if err, result = tc.Caught.Eval(vm); err != nil {
-			return err, nil
-		}
+	if err != nil {
+		return err, result
 	}
 	return nil, nil
 }
 
+// catches checks whether the catch of the TryCatch catches the given error value that was constructed by
+// errors.ConstructSttpError. If the catch has no Filters then every error is caught. Otherwise, the error must be an
+// Object with a "type" or "subset" that is one of the Filters.
+func (tc *TryCatch) catches(errVal interface{}) bool {
+	if len(tc.Filters) == 0 {
+		return true
+	}
+
+	errMap, ok := errVal.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for _, filter := range tc.Filters {
+		for _, key := range []string{"type", "subset"} {
+			if name, ok := errMap[key].(string); ok && name == filter {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Eval for FunctionDefinition will place the pointer to this AST node on the heap at the JSONPath. The
 // FunctionDefinition data.Value can only be Global and ReadOnly if the variable does not exist in the heap. Global is
 // only set when the current scope is 0, and ReadOnly is only set if the FunctionDefinition is being set to a root
@@ -731,6 +1031,76 @@ func (f *FunctionDefinition) Eval(vm VM) (err error, result *data.Value) {
 	return nil, nil
 }
 
+// Eval for Function creates a new anonymous FunctionDefinition that closes over the Heap of the current stack frame.
+// The FunctionDefinition has no JSONPath until it is assigned to a variable.
+func (f *Function) Eval(vm VM) (err error, result *data.Value) {
+	vm.SetPos(f.GetPos())
+	return nil, &data.Value{
+		Value: &FunctionDefinition{
+			Pos:     f.Pos,
+			Body:    f.Body,
+			closure: vm.GetCallStack().Current().GetHeap(),
+		},
+		Type: data.Function,
+	}
+}
+
+// Name returns the JSONPath of the FunctionDefinition as a string, or "anonymous" if the FunctionDefinition was
+// created by a Function that has not been assigned to a variable.
+func (f *FunctionDefinition) Name() string {
+	if f.JSONPath == nil {
+		return "anonymous"
+	}
+	return f.JSONPath.String(0)
+}
+
+// Closure returns the Heap of the stack frame that the FunctionDefinition was created within by a Function. This is nil
+// if the FunctionDefinition was defined using a function definition statement.
+func (f *FunctionDefinition) Closure() *data.Heap {
+	return f.closure
+}
+
+// computeArguments computes the Arguments of the FunctionCall in the order that they were given. The values of
+// positional arguments are returned in order, and the values of named arguments are returned by name. Returns an
+// errors.ArgumentError if a positional argument is given after a named argument, or if a name is given twice.
+func (f *FunctionCall) computeArguments(vm VM) (err error, args []*data.Value, named map[string]*data.Value) {
+	named = make(map[string]*data.Value)
+	for _, arg := range f.Arguments {
+		var computed *data.Value
+		if err, computed = arg.Value.Eval(vm); err != nil {
+			return err, nil, nil
+		}
+
+		vm.SetPos(arg.GetPos())
+		switch {
+		case arg.Name == nil && len(named) > 0:
+			return errors.ArgumentError.Errorf(vm, arg.Value.String(0), f.JSONPath.String(0), "positional arguments cannot follow named arguments"), nil, nil
+		case arg.Name == nil:
+			args = append(args, computed)
+		case named[*arg.Name] != nil:
+			return errors.ArgumentError.Errorf(vm, *arg.Name, f.JSONPath.String(0), "argument has already been given"), nil, nil
+		default:
+			named[*arg.Name] = computed
+		}
+	}
+	return nil, args, named
+}
+
+// positional returns the Expressions of the Arguments of the FunctionCall, so that they can be given to a
+// BuiltinFunction. Returns an errors.ArgumentError if any of the Arguments are named, as builtins do not have named
+// parameters.
+func (f *FunctionCall) positional(vm VM) (err error, uncomputedArgs []*Expression) {
+	uncomputedArgs = make([]*Expression, len(f.Arguments))
+	for i, arg := range f.Arguments {
+		if arg.Name != nil {
+			vm.SetPos(arg.GetPos())
+			return errors.ArgumentError.Errorf(vm, *arg.Name, f.JSONPath.String(0), "builtins cannot be given named arguments"), nil
+		}
+		uncomputedArgs[i] = arg.Value
+	}
+	return nil, uncomputedArgs
+}
+
 // Eval for FunctionCall will have the following steps of execution:
 //
 // 1. Increment the VM scope. This will be decremented in a deferred function. Then the JSONPath is evaluated.
@@ -740,10 +1110,10 @@ func (f *FunctionDefinition) Eval(vm VM) (err error, result *data.Value) {
 //
 // 3. If the value found is a pointer to a FunctionDefinition then we will evaluate all the Arguments, push a new Frame,
 //    evaluate the FunctionDefinition's body and then return from the new Frame. The result returned will be the return
-//    value from the popped frame.
+//    value from the popped frame. Named Arguments are passed to the new Frame separately to positional Arguments.
 //
 // 4. If the value found is a BuiltinFunction, then we will call the BuiltinFunction by passing all uncomputed Arguments
-//    to it.
+//    to it. BuiltinFunctions cannot be given named Arguments.
 func (f *FunctionCall) Eval(vm VM) (err error, result *data.Value) {
 	vm.SetPos(f.GetPos())
 	*vm.GetScope()++
@@ -778,11 +1148,12 @@ func (f *FunctionCall) Eval(vm VM) (err error, result *data.Value) {
 	switch result.Value.(type) {
 	case *FunctionDefinition:
 		var args []*data.Value
-		if err, args = computeArgs(vm, f.Arguments...); err != nil {
+		var named map[string]*data.Value
+		if err, args, named = f.computeArguments(vm); err != nil {
 			return err, nil
 		}
 		// Construct the new stack frame and put it on the callstack
-		if err = vm.GetCallStack().Call(f, result.Value.(*FunctionDefinition), vm, args...); err != nil {
+		if err = vm.GetCallStack().Call(f, result.Value.(*FunctionDefinition), vm, named, args...); err != nil {
 			return err, nil
 		}
 
@@ -793,16 +1164,17 @@ func (f *FunctionCall) Eval(vm VM) (err error, result *data.Value) {
 
 		// Evaluate the Block within the definition
 		if err, result = result.Value.(*FunctionDefinition).Body.Block.Eval(vm); err != nil {
-			switch err.(type) {
-			case errors.PurposefulError:
-				// If we have a purposeful error then we will check if it is Return. If so we will set err to nil.
-				if err.(errors.PurposefulError) == errors.Return {
-					err = nil
-					break
+			// If we have a purposeful error then we will check if it is Return. If so we will set err to nil.
+			if err == errors.Return {
+				err = nil
+			} else {
+				// Otherwise, the stack frame is popped before the error is propagated so that the error can be caught
+				// by a TryCatch outside the function
+				if _, ok := err.(errors.PurposefulError); !ok {
+					result = nil
 				}
+				_, _ = vm.GetCallStack().Return(vm)
 				return err, result
-			default:
-				return err, nil
 			}
 		}
 
@@ -821,7 +1193,11 @@ func (f *FunctionCall) Eval(vm VM) (err error, result *data.Value) {
 		if debug, ok := vm.GetDebug(); ok {
 			_, _ = fmt.Fprintf(debug, "calling builtin function %s args: %v\n", *f.JSONPath.Parts[0].Property, f.Arguments)
 		}
-		if err, result = result.Value.(BuiltinFunction)(vm, f.Arguments...); err != nil {
+		var uncomputedArgs []*Expression
+		if err, uncomputedArgs = f.positional(vm); err != nil {
+			return err, nil
+		}
+		if err, result = result.Value.(BuiltinFunction)(vm, uncomputedArgs...); err != nil {
 			return err, result
 		}
 	default:
@@ -904,6 +1280,87 @@ type jsonPath interface {
 	ASTNode
 }
 
+// resolveVariable will wait for every data.Promise within the given variable to settle, replacing them with the values
+// that they settled to. Promises only exist within a Batch, so this does nothing if the VM is not within one.
+func resolveVariable(vm VM, variable *data.Value) (err error) {
+	if vm.GetBatch() == nil || variable == nil {
+		return nil
+	}
+	var resolved interface{}
+	if err, resolved = data.Resolve(variable.Value); err != nil {
+		return errors.UpdateError(err, vm)
+	}
+	variable.Value = resolved
+	return errors.UpdateError(variable.Type.Get(resolved), vm)
+}
+
+// resolvePath will wait for the data.Promises that the given Path descends through within the given variable to
+// settle, so that the Path can be set. Promises that are stored elsewhere within the variable, such as those for the
+// other MethodCalls within the same Batch, are left alone. The value at the end of the Path is not resolved as it is
+// about to be replaced. If the Path contains a filter then the value being filtered is resolved in full, as the filter
+// reads every element of it. Promises only exist within a Batch, so this does nothing if the VM is not within one.
+func resolvePath(vm VM, variable *data.Value, path Path) (err error) {
+	if vm.GetBatch() == nil || variable == nil {
+		return nil
+	}
+
+	// settle resolves the given value if it is a Promise, or in full if the next element of the Path is a filter
+	settle := func(value interface{}, next interface{}) (err error, resolved interface{}) {
+		_, promise := value.(data.Promise)
+		if _, filter := next.(*Block); promise || filter {
+			if err, resolved = data.Resolve(value); err != nil {
+				return errors.UpdateError(err, vm), nil
+			}
+			return nil, resolved
+		}
+		return nil, value
+	}
+
+	if err, variable.Value = settle(variable.Value, path[1]); err != nil {
+		return err
+	}
+	if err = errors.UpdateError(variable.Type.Get(variable.Value), vm); err != nil {
+		return err
+	}
+
+	current := variable.Value
+	for i := 1; i < len(path)-1; i++ {
+		switch container := current.(type) {
+		case map[string]interface{}:
+			var key string
+			switch e := path[i].(type) {
+			case string:
+				key = e
+			case int:
+				var ok bool
+				if key, ok = firstKey(container, e); !ok {
+					return nil
+				}
+			default:
+				return nil
+			}
+			if err, container[key] = settle(container[key], path[i+1]); err != nil {
+				return err
+			}
+			current = container[key]
+		case []interface{}:
+			idx, ok := path[i].(int)
+			if !ok || abs(idx) >= len(container) {
+				return nil
+			}
+			idx = mod(idx, len(container))
+			if err, container[idx] = settle(container[idx], path[i+1]); err != nil {
+				return err
+			}
+			current = container[idx]
+		default:
+			// Filters have already been resolved in full, and any other value will be replaced
+			return nil
+		}
+	}
+	return nil
+}
+
 // jsonPathEval is called by both JSONPath.Eval and JSONPathFactor.Eval as the behaviour of both can be described as
 // agnostic using the above interface and the "reflect" package.
 func jsonPathEval(j jsonPath, vm VM) (err error, result *data.Value) {
@@ -923,6 +1380,10 @@ func jsonPathEval(j jsonPath, vm VM) (err error, result *data.Value) {
 		variableName := path[0].(string)
 		// Then we get the value of the variable from the heap so that we can set its new value appropriately.
 		variableVal = vm.GetCallStack().Current().GetHeap().Get(variableName)
+		// Reading a variable will wait for any Promises within it to settle
+		if err = resolveVariable(vm, variableVal); err != nil {
+			return err, nil
+		}
 	} else {
 		// The root property in a Path of j is a Value
 		variableVal = path[0].(*data.Value)
@@ -50,7 +50,8 @@ func (p *protoEvalNode) GetPos() lexer.Position { return p.getPosMethod() }
 
 // tEval evaluates an AST node which implements the term interface. This is done by first evaluating the left evalNode
 // and then iterating over all right-hand factors, using eval.Compute to compute the result of each operand and
-// accumulating the value in the data.Value that is returned.
+// accumulating the value in the data.Value that is returned. Right-hand factors of logical operators are skipped when
+// eval.ShortCircuit decides the result from the accumulated value alone.
 func tEval(t term, vm VM) (err error, result *data.Value) {
 	vm.SetPos(t.GetPos())
 	err, result = t.left().Eval(vm)
@@ -64,6 +65,15 @@ func tEval(t term, vm VM) (err error, result *data.Value) {
 
 	for _, r := range t.right() {
 		vm.SetPos(r.GetPos())
+		// The right-hand side of a logical operator is not evaluated if the left-hand side already decides the result
+		if short, ok := eval.ShortCircuit(r.operator(), result); ok {
+			if debug, ok := vm.GetDebug(); ok {
+				_, _ = fmt.Fprintf(debug, "\t%s: RHS (%s) short-circuited, new LHS = %v\n", r.GetPos().String(), reflect.TypeOf(r).String(), short)
+			}
+			result = short
+			continue
+		}
+
 		var right *data.Value
 		err, right = r.Eval(vm)
 
@@ -191,6 +201,12 @@ func (f *Factor) left() evalNode {
 		n = f.FunctionCall
 	case f.MethodCall != nil:
 		n = f.MethodCall
+	case f.Function != nil:
+		n = f.Function
+	case f.Unary != nil:
+		n = f.Unary
+	case f.Conditional != nil:
+		n = f.Conditional
 	case f.SubExpression != nil:
 		n = f.SubExpression
 	}
@@ -198,6 +214,38 @@ func (f *Factor) left() evalNode {
 }
 func (f *Factor) right() []factor { return make([]factor, 0) }
 
+// Eval for Unary evaluates the Factor and then computes the result of the UnaryOperator using eval.ComputeUnary.
+func (u *Unary) Eval(vm VM) (err error, result *data.Value) {
+	vm.SetPos(u.GetPos())
+	if err, result = u.Factor.Eval(vm); err != nil {
+		return err, nil
+	}
+	if err, result = u.Operator.Compute(result); err != nil {
+		return errors.UpdateError(err, vm), nil
+	}
+	return nil, result
+}
+
+// Eval for Conditional evaluates the Condition and casts it to a Boolean, in the same way as IfElifElse. Then only the
+// Then or the Else Expression is evaluated, and its result is returned.
+func (c *Conditional) Eval(vm VM) (err error, result *data.Value) {
+	vm.SetPos(c.GetPos())
+	var cond *data.Value
+	if err, cond = c.Condition.Eval(vm); err != nil {
+		return err, nil
+	}
+	if cond.Type != data.Boolean {
+		if err, cond = eval.Cast(cond, data.Boolean); err != nil {
+			return errors.UpdateError(err, vm), nil
+		}
+	}
+
+	if cond.Value.(bool) {
+		return c.Then.Eval(vm)
+	}
+	return c.Else.Eval(vm)
+}
+
 func (e *Expression) Eval(vm VM) (err error, result *data.Value)  { return tEval(e, vm) }
 func (p5 *Prec5) Eval(vm VM) (err error, result *data.Value)      { return fEval(p5, vm) }
 func (p5t *Prec5Term) Eval(vm VM) (err error, result *data.Value) { return tEval(p5t, vm) }
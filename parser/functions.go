@@ -8,6 +8,7 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func init() {
@@ -94,6 +95,9 @@ func init() {
 								Type:  data.Null,
 							}
 						}
+						if err = resolveVariable(vm, variableVal); err != nil {
+							return err, nil
+						}
 
 						// We set the value at the path to nil if we have more than element in the path.
 						if err, variableVal.Value = path.Set(vm, variableVal.Value, nil); err != nil {
@@ -115,6 +119,167 @@ func init() {
 				ReadOnly: false,
 			}
 		},
+		"cache": func(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+			var args []*data.Value
+			if err, args = computeArgs(vm, uncomputedArgs...); err != nil {
+				return err, nil
+			}
+
+			// If an argument is given, then we will cast it to a Boolean and enable or disable the HTTP cache
+			// accordingly. Enabling an already enabled cache will keep the responses that are currently cached.
+			client := vm.GetClient()
+			if len(args) > 0 {
+				var enable *data.Value
+				if err, enable = eval.Cast(args[0], data.Boolean); err != nil {
+					return errors.UpdateError(err, vm), nil
+				}
+				client.EnableCache(enable.Value.(bool))
+			}
+
+			return nil, &data.Value{
+				Value: client.Cache() != nil,
+				Type:  data.Boolean,
+			}
+		},
+		"clear_cache": func(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+			// Clearing the cache will return the number of responses that were cleared from the cache
+			cleared := 0
+			if cache := vm.GetClient().Cache(); cache != nil {
+				cleared = cache.Len()
+				cache.Clear()
+			}
+			return nil, &data.Value{
+				Value: float64(cleared),
+				Type:  data.Number,
+			}
+		},
+		"memoise": func(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+			var args []*data.Value
+			if err, args = computeArgs(vm, uncomputedArgs...); err != nil {
+				return err, nil
+			}
+
+			// A Boolean enables or disables memoisation, keeping the responses that are currently memoised if it is
+			// already enabled. A Number enables memoisation with a new Memo whose responses expire after that many
+			// seconds.
+			client := vm.GetClient()
+			if len(args) > 0 {
+				switch args[0].Type {
+				case data.Null:
+					client.SetMemo(nil)
+				case data.Boolean:
+					if !args[0].Value.(bool) {
+						client.SetMemo(nil)
+					} else if client.Memo() == nil {
+						client.SetMemo(eval.NewMemo(0))
+					}
+				default:
+					var ttl *data.Value
+					if err, ttl = eval.Cast(args[0], data.Number); err != nil {
+						return errors.UpdateError(err, vm), nil
+					}
+					if ttl.Value.(float64) < 0 {
+						return errors.InvalidOperation.Errorf(vm, "builtin:memoise", fmt.Sprintf("negative time-to-live: %s", ttl.String()), "memoise"), nil
+					}
+					client.SetMemo(eval.NewMemo(time.Duration(ttl.Value.(float64) * float64(time.Second))))
+				}
+			}
+
+			return nil, &data.Value{
+				Value: client.Memo() != nil,
+				Type:  data.Boolean,
+			}
+		},
+		"resolve": func(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+			var args []*data.Value
+			if err, args = computeArgs(vm, uncomputedArgs...); err != nil {
+				return err, nil
+			}
+
+			// Each key of the given Object is a "host:port" that will be resolved to the "ip:port" value. A null value
+			// removes the override for that "host:port".
+			client := vm.GetClient()
+			if len(args) > 0 {
+				overrides := args[0]
+				if overrides.Type != data.Object {
+					if err, overrides = eval.Cast(overrides, data.Object); err != nil {
+						return errors.UpdateError(err, vm), nil
+					}
+				}
+				for hostPort, addr := range overrides.Map() {
+					var addrString string
+					if addr != nil {
+						addrString = fmt.Sprintf("%v", addr)
+					}
+					if err = client.Resolve(hostPort, addrString); err != nil {
+						return errors.InvalidOperation.Errorf(vm, "builtin:resolve", fmt.Sprintf("\"%s\": %s", hostPort, err.Error()), "resolve"), nil
+					}
+				}
+			}
+
+			// The current overrides are returned
+			resolves := make(map[string]interface{})
+			for hostPort, addr := range client.Resolves() {
+				resolves[hostPort] = addr
+			}
+			return nil, &data.Value{
+				Value: resolves,
+				Type:  data.Object,
+			}
+		},
+		"paginate": func(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+			// The first argument must be a MethodCall, which is not called here, so that it can be called once for each
+			// page when the Paginator is iterated over.
+			var methodCall *MethodCall
+			if len(uncomputedArgs) == 0 {
+				return errors.PaginationError.Errorf(vm, "nothing", "a method call must be given"), nil
+			} else if methodCall = methodCallArg(uncomputedArgs[0]); methodCall == nil {
+				return errors.PaginationError.Errorf(vm, uncomputedArgs[0].String(0), "first argument must be a method call"), nil
+			}
+
+			var args []*data.Value
+			if err, args = computeArgs(vm, methodCall.Arguments...); err != nil {
+				return err, nil
+			}
+
+			var options []*data.Value
+			if err, options = computeArgs(vm, uncomputedArgs[1:]...); err != nil {
+				return err, nil
+			}
+
+			var paginator *Paginator
+			if len(options) > 0 {
+				err, paginator = NewPaginator(vm, methodCall.Method, args, options[0])
+			} else {
+				err, paginator = NewPaginator(vm, methodCall.Method, args, nil)
+			}
+			if err != nil {
+				return err, nil
+			}
+
+			return nil, &data.Value{
+				Value: paginator,
+				Type:  data.Iterable,
+			}
+		},
+		"batch_stats": func(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+			// The stats are copied so that the script cannot modify the stats of the last batch
+			stats := vm.GetBatchStats()
+			if stats == nil {
+				return nil, &data.Value{
+					Value: nil,
+					Type:  data.Null,
+				}
+			}
+			return nil, &data.Value{
+				Value: data.Copy(stats.Value),
+				Type:  stats.Type,
+			}
+		},
+		"async":     asyncBuiltin,
+		"await":     awaitBuiltin,
+		"await_all": awaitAllBuiltin,
+		"import":    importBuiltin,
 		"find": func(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
 			return findBuiltin(vm, false, false, uncomputedArgs...)
 		},
@@ -381,8 +546,9 @@ func find(search interface{}, searchSchema interface{}, partialSchema bool, all
 // MarshalJSON is used for marshalling FunctionDefinitions to JSON strings as they appear in the data.Heap. The returned
 // byte string is in the format:
 //  "function:RAW_JSON_PATH:FUNCTION_DEF_UINTPTR"
+// Where RAW_JSON_PATH is "anonymous" for anonymous functions that have not been assigned to a variable.
 func (f *FunctionDefinition) MarshalJSON() ([]byte, error) {
-	return []byte(fmt.Sprintf("\"function:%s:%d\"", f.JSONPath.String(0), reflect.ValueOf(f).Pointer())), nil
+	return []byte(fmt.Sprintf("\"function:%s:%d\"", f.Name(), reflect.ValueOf(f).Pointer())), nil
 }
 
 // BuiltinFunction denotes the signature of each builtin function in the builtins table.
@@ -2,11 +2,14 @@ package parser
 
 import (
 	"container/heap"
+	"context"
 	"fmt"
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/andygello555/data"
 	"github.com/andygello555/errors"
+	"github.com/andygello555/eval"
 	"io"
+	"time"
 )
 
 // ASTNode is implemented by all ASTNodes
@@ -49,28 +52,47 @@ type VM interface {
 	GetDebug() (io.Writer, bool)
 	// WriteDebug will write the format string and its arguments to the debug io.Writer.
 	WriteDebug(format string, a ...interface{})
-	// GetBatch will return the BatchSuite as well as the batch results if there are any.
-	GetBatch() (BatchSuite, heap.Interface)
-	// DeleteBatch will set both the BatchSuite and the batch results to be nil. Forcing them to be garbage collected.
+	// GetBatch will return the BatchSuite. This will be nil if the VM is not within a Batch statement.
+	GetBatch() BatchSuite
+	// DeleteBatch will stop the BatchSuite and set it to be nil. Forcing it to be garbage collected.
 	DeleteBatch()
 	// CreateBatch will create a new BatchSuite for the given Batch AST node.
 	CreateBatch(statement *Batch)
-	// StartBatch will start the worker threads for the batch, ready to execute any MethodCall(s) enqueued as work.
-	StartBatch()
-	// StopBatch will stop indicate to the internal Batch that there is no more work to execute and that we want to wait
-	// for the workers to be finish. It should also set the BatchResults field to the results of this Batch.
-	StopBatch()
+	// StartBatch will start the given number of worker threads for the batch, ready to execute any MethodCall(s)
+	// enqueued as work. If the number of workers is less than 1, then the VM's default number of workers is started.
+	StartBatch(workers int)
+	// GetBatchStats will return the summary of the last Batch statement that was evaluated, or nil if no Batch
+	// statement has been evaluated.
+	GetBatchStats() *data.Value
+	// StopBatch will indicate to the internal Batch that there is no more work to execute and that we want to wait for
+	// the workers to finish. It returns the error of the first MethodCall that failed without its result being read.
+	StopBatch() error
 	// GetEnvironment will return the currently used environment, or nil if there is no environment.
 	GetEnvironment() (err error, env Env)
 	// CheckREPL will return whether the VM is in REPL mode.
 	CheckREPL() bool
+	// GetClient will return the eval.Client used to make HTTP method calls.
+	GetClient() *eval.Client
+	// Async will execute the given function on the VM's shared pool of workers, and return a data.Promise for its
+	// result. The context.Context given to the function is cancelled if the data.Promise is cancelled.
+	Async(fn func(ctx context.Context) (err error, result *data.Value)) data.Promise
+	// RecordCall will record that the given MethodCall took the given amount of time to make, and whether it failed.
+	// This must be safe to call from multiple goroutines.
+	RecordCall(method *MethodCall, elapsed time.Duration, err error)
+	// Import will evaluate the sttp script at the given path within its own stack frame, and return the namespace
+	// Object of its global values. Relative paths are resolved relative to the script that is currently being evaluated.
+	// Each module is only evaluated once, after which its namespace is returned from a cache.
+	Import(path string) (err error, namespace *data.Value)
+	// Fork will create a new VM that can evaluate code concurrently with this one. The new VM has its own copy of the
+	// variables on the current stack frame.
+	Fork() VM
 }
 
 // CallStack is implemented by the call stack that is used within the VM.
 type CallStack interface {
 	// Call will add a new stack frame to the call stack with the given fields. It will also create a new Heap
 	// accordingly with the given computed arguments as values on the Heap.
-	Call(caller *FunctionCall, current *FunctionDefinition, vm VM, args ...*data.Value) error
+	Call(caller *FunctionCall, current *FunctionDefinition, vm VM, named map[string]*data.Value, args ...*data.Value) error
 	// Return will remove the top frame from the call stack and return it.
 	Return(vm VM) (err error, frame Frame)
 	// Current will return the top of the call stack but not remove it.
@@ -116,10 +138,16 @@ type BatchResult interface {
 
 // BatchSuite represents the suite that is used to execute a Batch statement.
 type BatchSuite interface {
-	AddWork(method *MethodCall, args ...*data.Value)
+	AddWork(method *MethodCall, args ...*data.Value) data.Promise
+	Assigned(variable *data.Value)
+	Settle()
+	Err() error
+	Collect() []BatchResult
 	GetStatement() *Batch
+	Within(timeout time.Duration)
 	Start(workers int)
 	Stop() heap.Interface
+	Stats() *data.Value
 }
 
 // Env represents an environment variable that can be passed to a VM to set a global constant.
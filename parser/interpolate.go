@@ -0,0 +1,241 @@
+package parser
+
+import (
+	"github.com/alecthomas/participle/v2"
+	"github.com/alecthomas/participle/v2/lexer"
+	"github.com/andygello555/data"
+	"github.com/andygello555/errors"
+	"github.com/andygello555/eval"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// interpolationParser parses the Expressions that are interpolated within a StringLit.
+var interpolationParser = participle.MustBuild(&Expression{},
+	participle.Lexer(Lex),
+	participle.CaseInsensitive("Ident"),
+	participle.UseLookahead(2),
+)
+
+// quotedRune is a single rune of a string literal token, once its escape sequences have been decoded. escaped is set if
+// the rune was produced by an escape sequence, and pos is the position of the rune, or its escape sequence, within the
+// source.
+type quotedRune struct {
+	r       rune
+	escaped bool
+	pos     lexer.Position
+}
+
+// stringLitError is panicked by StringLit.Parse when a StringLit token cannot be parsed. participle reports the error
+// of the branch that got the furthest, and would replace the error with a less informative one. As StringLit tokens
+// can only be parsed by StringLit.Parse, the error occurs no matter which branch is taken, so parsing can stop
+// straight away. The error is recovered by recoverStringLit.
+type stringLitError struct {
+	error
+}
+
+// recoverStringLit recovers a stringLitError that was panicked whilst parsing, and sets the given error to it.
+func recoverStringLit(err *error) {
+	if p := recover(); p != nil {
+		if stringLitErr, ok := p.(stringLitError); ok {
+			*err = stringLitErr.error
+			return
+		}
+		panic(p)
+	}
+}
+
+// Parse will parse a StringLit from the next StringLit or RawString token. The contents of a RawString token are used
+// as they are. Otherwise, the escape sequences within the token are decoded, then the token is split into text and
+// interpolated Expressions. An interpolated Expression starts with "{{" and ends with the first "}}" that is not nested
+// within an Object literal or a string literal of its own. The "\{" and "\}" escape sequences produce literal braces
+// which never start or end an interpolation. If the token cannot be parsed then a stringLitError is panicked.
+//
+// Each interpolated Expression is parsed at its position within the source, so that parse and runtime errors point to
+// the correct line and column. Note that quotes within an interpolated Expression must be escaped, as they are within
+// the rest of the string literal.
+func (s *StringLit) Parse(lex *lexer.PeekingLexer) error {
+	token, err := lex.Peek(0)
+	if err != nil {
+		return err
+	}
+	symbols := Lex.Symbols()
+	if token.Type != symbols["StringLit"] && token.Type != symbols["RawString"] {
+		return participle.NextMatch
+	}
+	_, _ = lex.Next()
+	s.Pos = token.Pos
+
+	if token.Type == symbols["RawString"] {
+		// The contents of a raw string literal are used as they are
+		pos := token.Pos
+		pos.Column += 3
+		pos.Offset += 3
+		s.Raw = true
+		if text := strings.TrimSuffix(strings.TrimPrefix(token.Value, `"""`), `"""`); text != "" {
+			s.Segments = []*StringSegment{{Pos: pos, Text: text}}
+		}
+		return nil
+	}
+
+	if err = s.parse(token); err != nil {
+		panic(stringLitError{err})
+	}
+	return nil
+}
+
+// parse splits the given StringLit token into the Segments of the StringLit.
+func (s *StringLit) parse(token lexer.Token) (err error) {
+	var runes []quotedRune
+	if err, runes = unquoteStringLit(token); err != nil {
+		return err
+	}
+
+	var text strings.Builder
+	var textPos lexer.Position
+	flush := func() {
+		if text.Len() > 0 {
+			s.Segments = append(s.Segments, &StringSegment{Pos: textPos, Text: text.String()})
+			text.Reset()
+		}
+	}
+
+	for i := 0; i < len(runes); i++ {
+		if runes[i].r != '{' || runes[i].escaped || i+1 == len(runes) || runes[i+1].r != '{' || runes[i+1].escaped {
+			if text.Len() == 0 {
+				textPos = runes[i].pos
+			}
+			text.WriteRune(runes[i].r)
+			continue
+		}
+		flush()
+
+		end := interpolationEnd(runes, i+2)
+		if end == -1 {
+			return participle.Errorf(runes[i].pos, "interpolation in string literal is missing a closing \"}}\"")
+		}
+
+		// The Expression starts straight after the opening braces, which are never escaped so are on the same line
+		pos := runes[i].pos
+		pos.Column += 2
+		pos.Offset += 2
+		var expression *Expression
+		if err, expression = parseInterpolation(pos, runes[i+2:end]); err != nil {
+			return err
+		}
+		s.Segments = append(s.Segments, &StringSegment{Pos: runes[i].pos, Interpolation: expression})
+		i = end + 1
+	}
+	flush()
+	return nil
+}
+
+// unquoteStringLit decodes the escape sequences within the given StringLit token in the same way as strconv.Unquote,
+// along with the "\{" and "\}" escape sequences. The position of each decoded rune is also returned.
+func unquoteStringLit(token lexer.Token) (err error, runes []quotedRune) {
+	pos := token.Pos
+	advance := func(raw string) {
+		for _, r := range raw {
+			if r == '\n' {
+				pos.Line++
+				pos.Column = 1
+			} else {
+				pos.Column++
+			}
+			pos.Offset += utf8.RuneLen(r)
+		}
+	}
+
+	// Skip the opening quote
+	advance(token.Value[:1])
+	raw := token.Value[1 : len(token.Value)-1]
+	for raw != "" {
+		q := quotedRune{pos: pos}
+		var tail string
+		if len(raw) > 1 && raw[0] == '\\' && (raw[1] == '{' || raw[1] == '}') {
+			q.r, q.escaped, tail = rune(raw[1]), true, raw[2:]
+		} else {
+			if q.r, _, tail, err = strconv.UnquoteChar(raw, '"'); err != nil {
+				return participle.Errorf(pos, "invalid quoted string %q: %s", token.Value, err.Error()), nil
+			}
+			q.escaped = raw[0] == '\\'
+		}
+		advance(raw[:len(raw)-len(tail)])
+		raw = tail
+		runes = append(runes, q)
+	}
+	return nil, runes
+}
+
+// interpolationEnd finds the index of the closing "}}" of the interpolation that starts at the given index. Braces and
+// string literals within the interpolated Expression are skipped over. Returns -1 if the interpolation is not closed.
+func interpolationEnd(runes []quotedRune, start int) int {
+	depth := 0
+	inString := false
+	for i := start; i < len(runes); i++ {
+		switch r := runes[i].r; {
+		case inString:
+			if r == '\\' {
+				i++
+			} else if r == '"' {
+				inString = false
+			}
+		case r == '"':
+			inString = true
+		case r == '{':
+			depth++
+		case r == '}':
+			if depth == 0 && i+1 < len(runes) && runes[i+1].r == '}' {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// parseInterpolation parses the given runes as an Expression which starts at the given position. The source is padded
+// so that the positions of the Expression's nodes match their positions within the source of the StringLit.
+func parseInterpolation(pos lexer.Position, runes []quotedRune) (err error, expression *Expression) {
+	var b strings.Builder
+	b.WriteString(strings.Repeat("\n", pos.Line-1))
+	b.WriteString(strings.Repeat(" ", pos.Column-1))
+	for _, q := range runes {
+		b.WriteRune(q.r)
+	}
+
+	expression = &Expression{}
+	if err = interpolationParser.ParseString(pos.Filename, b.String(), expression); err != nil {
+		return err, nil
+	}
+	return nil, expression
+}
+
+// Eval for StringLit evaluates each interpolated Expression and casts its result to a String, if it is not already.
+// The results are then concatenated with the text around them.
+func (s *StringLit) Eval(vm VM) (err error, result *data.Value) {
+	var b strings.Builder
+	for _, segment := range s.Segments {
+		if segment.Interpolation == nil {
+			b.WriteString(segment.Text)
+			continue
+		}
+
+		var value *data.Value
+		if err, value = segment.Interpolation.Eval(vm); err != nil {
+			return err, nil
+		}
+		if value.Type != data.String {
+			vm.SetPos(segment.GetPos())
+			if err, value = eval.Cast(value, data.String); err != nil {
+				return errors.UpdateError(err, vm), nil
+			}
+		}
+		b.WriteString(value.StringLit())
+	}
+	return nil, &data.Value{
+		Value: b.String(),
+		Type:  data.String,
+	}
+}
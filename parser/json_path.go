@@ -590,11 +590,12 @@ func (jp *JSONPart) Convert(vm VM) (err error, path Path) {
 
 // Convert will convert a StringLitPart AST node into an iterable Path.
 func (slp *StringLitPart) Convert(vm VM) (err error, path Path) {
+	var str *data.Value
+	if err, str = slp.StringLit.Eval(vm); err != nil {
+		return err, nil
+	}
 	path = make(Path, 0)
-	path = append(path, &data.Value{
-		Value: *slp.StringLit,
-		Type:  data.String,
-	})
+	path = append(path, str)
 
 	for _, i := range slp.Indices {
 		if err = path.ConvertAppend(i, vm); err != nil {
@@ -0,0 +1,82 @@
+package parser
+
+import (
+	"github.com/andygello555/data"
+	"github.com/andygello555/errors"
+	"github.com/andygello555/eval"
+)
+
+// importBuiltin imports the sttp script at the path given as its only argument, and returns its namespace Object. The
+// path is cast to a String, and is resolved by the VM relative to the script that is currently being evaluated.
+//
+// The namespace is copied each time it is returned, so that modifying it does not change the namespace seen by any
+// other importers of the same module.
+func importBuiltin(vm VM, uncomputedArgs ...*Expression) (err error, value *data.Value) {
+	var args []*data.Value
+	if err, args = computeArgs(vm, uncomputedArgs...); err != nil {
+		return err, nil
+	}
+	if len(args) != 1 {
+		return errors.ImportError.Errorf(vm, "nothing", "a single path must be given"), nil
+	}
+
+	path := args[0]
+	if path.Type != data.String {
+		if err, path = eval.Cast(path, data.String); err != nil {
+			return errors.UpdateError(err, vm), nil
+		}
+	}
+
+	var namespace *data.Value
+	if err, namespace = vm.Import(path.StringLit()); err != nil {
+		return err, namespace
+	}
+	return nil, &data.Value{
+		Value: data.Copy(namespace.Value),
+		Type:  data.Object,
+	}
+}
+
+// Module returns the Heap of the bottommost stack frame of the module that the FunctionDefinition was imported from.
+// This is nil if the FunctionDefinition was not imported.
+func (f *FunctionDefinition) Module() *data.Heap {
+	return f.module
+}
+
+// Namespace constructs the namespace Object of a module from the Heap of the module's bottommost stack frame, once the
+// module has been evaluated. Every value on the Heap, apart from the environment, is exposed as a property of the
+// namespace. Every function defined within the module is bound to the Heap so that, when called, it can refer to the
+// other global values of the module rather than those of its caller.
+func Namespace(module *data.Heap) *data.Value {
+	namespace := make(map[string]interface{})
+	for name, val := range *module {
+		if name == "env" {
+			continue
+		}
+		bindModule(val.Value, module)
+		namespace[name] = data.Copy(val.Value)
+	}
+	return &data.Value{
+		Value: namespace,
+		Type:  data.Object,
+	}
+}
+
+// bindModule binds every FunctionDefinition within the given value to the given module Heap. FunctionDefinitions that
+// are already bound, such as those imported by the module from another module, are left as they are.
+func bindModule(value interface{}, module *data.Heap) {
+	switch value.(type) {
+	case *FunctionDefinition:
+		if f := value.(*FunctionDefinition); f.module == nil {
+			f.module = module
+		}
+	case map[string]interface{}:
+		for _, elem := range value.(map[string]interface{}) {
+			bindModule(elem, module)
+		}
+	case []interface{}:
+		for _, elem := range value.([]interface{}) {
+			bindModule(elem, module)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package parser
+
+import (
+	"fmt"
+	"github.com/andygello555/data"
+	"github.com/andygello555/errors"
+	"github.com/andygello555/eval"
+	"net/url"
+	"strings"
+)
+
+// DefaultMaxPages is the maximum number of pages that a Paginator will fetch if no "max_pages" option is given.
+const DefaultMaxPages = 100
+
+// PaginationStrategy is the way in which a Paginator finds the next page to fetch from the page that it has just
+// fetched.
+type PaginationStrategy int
+
+const (
+	// LinkPagination follows the URL with the "next" relation within the RFC 5988 Link header of each page.
+	LinkPagination PaginationStrategy = iota
+	// NextPagination follows the URL found at a JSONPath within each page.
+	NextPagination
+	// CursorPagination sets a query parameter of the initial URL to the cursor found at a JSONPath within each page.
+	CursorPagination
+)
+
+var paginationStrategyNames = map[PaginationStrategy]string{
+	LinkPagination:   "link",
+	NextPagination:   "next",
+	CursorPagination: "cursor",
+}
+
+func (ps PaginationStrategy) String() string {
+	return paginationStrategyNames[ps]
+}
+
+// Paginator is a data.LazyIterable which fetches the pages of a paginated endpoint one at a time. Each page is the
+// response Object of the MethodCall for that page, keyed by the index of the page.
+type Paginator struct {
+	vm     VM
+	method eval.Method
+	args   []*data.Value
+	// Strategy is the PaginationStrategy used to find the next page.
+	Strategy PaginationStrategy
+	// Path is the Path to the next URL, for NextPagination, or the next cursor, for CursorPagination. The root of the
+	// Path is the response Object of each page.
+	Path Path
+	// Cursor is the name of the query parameter that the cursor is set to, for CursorPagination.
+	Cursor string
+	// MaxPages is the maximum number of pages that will be fetched.
+	MaxPages int
+}
+
+// NewPaginator creates a Paginator for the given Method and its computed arguments. The options Object can contain the
+// following keys:
+//  {
+//      // The JSONPath to the URL of the next page within each response (NextPagination).
+//      "next": "content.links.next",
+//      // The query parameter to set to the cursor found at "cursor_path" (CursorPagination).
+//      "cursor": "cursor",
+//      "cursor_path": "content.meta.next_cursor",
+//      // The maximum number of pages to fetch. Defaults to DefaultMaxPages.
+//      "max_pages": 10,
+//  }
+// If neither "next" nor "cursor" are given, then LinkPagination will be used. The options can be nil.
+func NewPaginator(vm VM, method eval.Method, args []*data.Value, options *data.Value) (err error, paginator *Paginator) {
+	paginator = &Paginator{
+		vm:       vm,
+		method:   method,
+		args:     args,
+		Strategy: LinkPagination,
+		MaxPages: DefaultMaxPages,
+	}
+
+	if len(args) == 0 {
+		return errors.PaginationError.Errorf(vm, method.String(), "no URL given"), nil
+	}
+	if options == nil {
+		return nil, paginator
+	}
+	if options.Type != data.Object {
+		return errors.PaginationError.Errorf(vm, args[0].String(), fmt.Sprintf("options must be an object not %s", options.Type.String())), nil
+	}
+
+	// path parses the JSONPath string under the given key and converts it to a Path rooted at each page.
+	path := func(key string, v interface{}) (err error, path Path) {
+		var s string
+		var ok bool
+		if s, ok = v.(string); !ok {
+			return errors.PaginationError.Errorf(vm, args[0].String(), fmt.Sprintf("\"%s\" must be a string", key)), nil
+		}
+		var jsonPath *JSONPath
+		if err, jsonPath = ParseJSONPath(s); err != nil {
+			return errors.PaginationError.Errorf(vm, args[0].String(), fmt.Sprintf("\"%s\" is not a valid JSONPath: %s", key, err.Error())), nil
+		}
+		if err, path = jsonPath.Convert(vm); err != nil {
+			return err, nil
+		}
+		// Path.Get skips the root of the Path, as it is usually the name of a variable, so we add a placeholder root.
+		return nil, append(Path{"page"}, path...)
+	}
+
+	var cursorPath Path
+	for key, v := range options.Map() {
+		switch key {
+		case "next":
+			paginator.Strategy = NextPagination
+			if err, paginator.Path = path(key, v); err != nil {
+				return err, nil
+			}
+		case "cursor":
+			var ok bool
+			if paginator.Cursor, ok = v.(string); !ok {
+				return errors.PaginationError.Errorf(vm, args[0].String(), "\"cursor\" must be a string"), nil
+			}
+		case "cursor_path":
+			if err, cursorPath = path(key, v); err != nil {
+				return err, nil
+			}
+		case "max_pages":
+			var maxPages *data.Value
+			if err, maxPages = eval.CastInterface(v, data.Number); err != nil {
+				return errors.UpdateError(err, vm), nil
+			}
+			paginator.MaxPages = maxPages.Int()
+		default:
+			return errors.PaginationError.Errorf(vm, args[0].String(), fmt.Sprintf("unknown option \"%s\"", key)), nil
+		}
+	}
+
+	if paginator.Cursor != "" || cursorPath != nil {
+		switch {
+		case paginator.Strategy == NextPagination:
+			return errors.PaginationError.Errorf(vm, args[0].String(), "cannot use both \"next\" and \"cursor\""), nil
+		case paginator.Cursor == "" || cursorPath == nil:
+			return errors.PaginationError.Errorf(vm, args[0].String(), "\"cursor\" and \"cursor_path\" must be given together"), nil
+		}
+		paginator.Strategy = CursorPagination
+		paginator.Path = cursorPath
+	}
+	return nil, paginator
+}
+
+// urlOf returns the URL argument of a MethodCall as a string.
+func urlOf(arg *data.Value) string {
+	if arg.Type == data.String {
+		return arg.StringLit()
+	}
+	return arg.String()
+}
+
+// linkNext finds the URL with the "next" relation within the given RFC 5988 Link header values. Returns an empty
+// string if there is no such URL.
+func linkNext(links []string) string {
+	for _, header := range links {
+		for _, link := range strings.Split(header, ",") {
+			params := strings.Split(link, ";")
+			target := strings.TrimSpace(params[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			for _, param := range params[1:] {
+				if i := strings.Index(param, "="); i >= 0 && strings.EqualFold(strings.TrimSpace(param[:i]), "rel") {
+					// The rel parameter can contain multiple space separated relation types
+					for _, rel := range strings.Fields(strings.Trim(strings.TrimSpace(param[i+1:]), "\"")) {
+						if strings.EqualFold(rel, "next") {
+							return target[1 : len(target)-1]
+						}
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// next finds the URL of the page after the given page, which was fetched from the given URL. Returns an empty string if
+// there is no next page.
+func (p *Paginator) next(current string, page *data.Value) (err error, next string) {
+	var found interface{}
+	switch p.Strategy {
+	case LinkPagination:
+		var links []string
+		if headers, ok := page.Map()["headers"].(map[string]interface{}); ok {
+			switch headers["Link"].(type) {
+			case []string:
+				links = headers["Link"].([]string)
+			case []interface{}:
+				for _, link := range headers["Link"].([]interface{}) {
+					links = append(links, fmt.Sprintf("%v", link))
+				}
+			}
+		}
+		if next = linkNext(links); next == "" {
+			return nil, ""
+		}
+	case NextPagination, CursorPagination:
+		if err, found = p.Path.Get(p.vm, page.Value); err != nil {
+			return errors.UpdateError(err, p.vm), ""
+		}
+		switch found.(type) {
+		case nil:
+			return nil, ""
+		case string:
+			next = found.(string)
+		default:
+			next = (&data.Value{Value: found}).String()
+		}
+		if next == "" {
+			return nil, ""
+		}
+
+		if p.Strategy == CursorPagination {
+			// The cursor is always set on the URL of the first page
+			var u *url.URL
+			if u, err = url.Parse(urlOf(p.args[0])); err != nil {
+				return errors.PaginationError.Errorf(p.vm, urlOf(p.args[0]), err.Error()), ""
+			}
+			query := u.Query()
+			query.Set(p.Cursor, next)
+			u.RawQuery = query.Encode()
+			return nil, u.String()
+		}
+	}
+
+	// Relative URLs are resolved against the URL of the current page
+	var base, ref *url.URL
+	if base, err = url.Parse(current); err == nil {
+		if ref, err = url.Parse(next); err == nil {
+			return nil, base.ResolveReference(ref).String()
+		}
+	}
+	return errors.PaginationError.Errorf(p.vm, current, err.Error()), ""
+}
+
+// Generate returns a data.Generator that fetches the next page each time it is called. Pages are fetched until there
+// is no next page, the next page is the same as the current page, or MaxPages pages have been fetched.
+func (p *Paginator) Generate() data.Generator {
+	args := make([]*data.Value, len(p.args))
+	copy(args, p.args)
+	page := 0
+	return func() (err error, elem *data.Element) {
+		if args == nil || page >= p.MaxPages {
+			return nil, nil
+		}
+
+		var response *data.Value
+		if err, response = p.method.Call(p.vm.GetClient(), args...); err != nil {
+			return errors.UpdateError(err, p.vm), nil
+		}
+
+		current := urlOf(args[0])
+		var next string
+		if err, next = p.next(current, response); err != nil {
+			return err, nil
+		}
+		if next == "" || next == current {
+			args = nil
+		} else {
+			args[0] = &data.Value{Value: next, Type: data.String}
+		}
+
+		elem = &data.Element{
+			Key: &data.Value{
+				Value:    float64(page),
+				Type:     data.Number,
+				Global:   false,
+				ReadOnly: true,
+			},
+			Val: &data.Value{
+				Value:    response.Value,
+				Type:     response.Type,
+				Global:   false,
+				ReadOnly: true,
+			},
+		}
+		page++
+		return nil, elem
+	}
+}
+
+// MarshalJSON is used for marshalling Paginators to JSON strings as they appear in the data.Heap. The returned byte
+// string is in the format:
+//  "paginator:STRATEGY:METHOD:URL"
+func (p *Paginator) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%q", fmt.Sprintf("paginator:%s:%s:%s", p.Strategy.String(), p.method.String(), urlOf(p.args[0])))), nil
+}
+
+// loneArg descends the given uncomputed argument to find the lone node that it consists of. I.e. a node that is not
+// combined with any other nodes using operators. Returns nil if there is no such node.
+func loneArg(uncomputedArg *Expression) evalNode {
+	var node evalNode = uncomputedArg
+	for {
+		switch node.(type) {
+		case term:
+			if len(node.(term).right()) > 0 {
+				return nil
+			}
+			node = node.(term).left()
+		default:
+			return node
+		}
+	}
+}
+
+// methodCallArg descends the given uncomputed argument to find the lone MethodCall that it consists of. Returns nil if
+// the argument is not a lone MethodCall.
+func methodCallArg(uncomputedArg *Expression) *MethodCall {
+	methodCall, _ := loneArg(uncomputedArg).(*MethodCall)
+	return methodCall
+}
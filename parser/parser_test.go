@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"github.com/andygello555/data"
 	"github.com/andygello555/eval"
 	"testing"
 )
@@ -516,3 +517,218 @@ func TestPath_Get(t *testing.T) {
 		}
 	}
 }
+
+func TestLinkNext(t *testing.T) {
+	for testNo, test := range []struct {
+		links    []string
+		expected string
+	}{
+		{
+			links:    []string{`<http://127.0.0.1:3000/page/2>; rel="next"`},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			links:    []string{`<http://127.0.0.1:3000/page/2>; rel=next`},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			links:    []string{`<http://127.0.0.1:3000/page/2> ; REL = "Next"`},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			// Multiple relation types within a single rel parameter
+			links:    []string{`<http://127.0.0.1:3000/page/2>; rel="next last"`},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			// Multiple links within a single header
+			links:    []string{`<http://127.0.0.1:3000/page/0>; rel="prev first", <http://127.0.0.1:3000/page/2>; rel="next", <http://127.0.0.1:3000/page/9>; rel="last"`},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			// Multiple headers
+			links: []string{
+				`<http://127.0.0.1:3000/page/0>; rel="prev"`,
+				`<http://127.0.0.1:3000/page/2>; rel="next"`,
+			},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			// Other parameters before the rel parameter
+			links:    []string{`<http://127.0.0.1:3000/page/2>; title="next page"; type="application/json"; rel="next"`},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			// Relative URLs are returned as they are
+			links:    []string{`</page/2?per_page=10&cursor=abc>; rel="next"`},
+			expected: "/page/2?per_page=10&cursor=abc",
+		},
+		{
+			// The first link with the next relation is used
+			links:    []string{`<http://127.0.0.1:3000/page/2>; rel="next", <http://127.0.0.1:3000/page/3>; rel="next"`},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			// Relation types that only contain next are not next
+			links:    []string{`<http://127.0.0.1:3000/page/2>; rel="nextpage"; title="next"`},
+			expected: "",
+		},
+		{
+			// Targets that are not enclosed in angle brackets are skipped
+			links:    []string{`http://127.0.0.1:3000/page/2; rel="next"`, `<http://127.0.0.1:3000/page/3>; rel="next"`},
+			expected: "http://127.0.0.1:3000/page/3",
+		},
+		{
+			links:    []string{`<http://127.0.0.1:3000/page/0>; rel="prev", <http://127.0.0.1:3000/page/9>; rel="last"`},
+			expected: "",
+		},
+		{
+			links:    []string{`<http://127.0.0.1:3000/page/2>`},
+			expected: "",
+		},
+		{
+			links:    []string{""},
+			expected: "",
+		},
+		{
+			links:    nil,
+			expected: "",
+		},
+	} {
+		if next := linkNext(test.links); next != test.expected {
+			t.Errorf("next %q for testNo: %d does not match the required next: %q", next, testNo+1, test.expected)
+		}
+	}
+}
+
+func TestPaginator_next(t *testing.T) {
+	for testNo, test := range []struct {
+		paginator *Paginator
+		current   string
+		page      interface{}
+		expected  string
+	}{
+		{
+			paginator: &Paginator{Strategy: LinkPagination},
+			current:   "http://127.0.0.1:3000/page/1",
+			page: map[string]interface{}{
+				"headers": map[string]interface{}{
+					"Link": []interface{}{`<http://127.0.0.1:3000/page/0>; rel="prev", <http://127.0.0.1:3000/page/2>; rel="next"`},
+				},
+			},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			// Relative links are resolved against the current URL
+			paginator: &Paginator{Strategy: LinkPagination},
+			current:   "http://127.0.0.1:3000/page/1?per_page=10",
+			page: map[string]interface{}{
+				"headers": map[string]interface{}{
+					"Link": []string{`<2?per_page=10>; rel="next"`},
+				},
+			},
+			expected: "http://127.0.0.1:3000/page/2?per_page=10",
+		},
+		{
+			paginator: &Paginator{Strategy: LinkPagination},
+			current:   "http://127.0.0.1:3000/page/9",
+			page: map[string]interface{}{
+				"headers": map[string]interface{}{
+					"Link": []interface{}{`<http://127.0.0.1:3000/page/8>; rel="prev"`},
+				},
+			},
+			expected: "",
+		},
+		{
+			paginator: &Paginator{Strategy: LinkPagination},
+			current:   "http://127.0.0.1:3000/page/1",
+			page: map[string]interface{}{
+				"headers": map[string]interface{}{},
+			},
+			expected: "",
+		},
+		{
+			paginator: &Paginator{Strategy: NextPagination, Path: Path{"page", "content", "links", "next"}},
+			current:   "http://127.0.0.1:3000/page/1",
+			page: map[string]interface{}{
+				"content": map[string]interface{}{
+					"links": map[string]interface{}{"next": "/page/2"},
+				},
+			},
+			expected: "http://127.0.0.1:3000/page/2",
+		},
+		{
+			paginator: &Paginator{Strategy: NextPagination, Path: Path{"page", "content", "links", "next"}},
+			current:   "http://127.0.0.1:3000/page/9",
+			page: map[string]interface{}{
+				"content": map[string]interface{}{
+					"links": map[string]interface{}{"next": nil},
+				},
+			},
+			expected: "",
+		},
+		{
+			paginator: &Paginator{Strategy: NextPagination, Path: Path{"page", "content", "links", "next"}},
+			current:   "http://127.0.0.1:3000/page/9",
+			page: map[string]interface{}{
+				"content": map[string]interface{}{},
+			},
+			expected: "",
+		},
+		{
+			// The cursor is set on the URL of the first page, replacing any cursor that is already there
+			paginator: &Paginator{
+				args:     []*data.Value{{Value: "http://127.0.0.1:3000/items?cursor=a&per_page=10", Type: data.String}},
+				Strategy: CursorPagination,
+				Path:     Path{"page", "content", "meta", "next_cursor"},
+				Cursor:   "cursor",
+			},
+			current: "http://127.0.0.1:3000/items?cursor=b&per_page=10",
+			page: map[string]interface{}{
+				"content": map[string]interface{}{
+					"meta": map[string]interface{}{"next_cursor": "c d"},
+				},
+			},
+			expected: "http://127.0.0.1:3000/items?cursor=c+d&per_page=10",
+		},
+		{
+			// Cursors that are not strings are converted to strings
+			paginator: &Paginator{
+				args:     []*data.Value{{Value: "http://127.0.0.1:3000/items", Type: data.String}},
+				Strategy: CursorPagination,
+				Path:     Path{"page", "content", "meta", "next_cursor"},
+				Cursor:   "after",
+			},
+			current: "http://127.0.0.1:3000/items",
+			page: map[string]interface{}{
+				"content": map[string]interface{}{
+					"meta": map[string]interface{}{"next_cursor": 20.0},
+				},
+			},
+			expected: "http://127.0.0.1:3000/items?after=20",
+		},
+		{
+			paginator: &Paginator{
+				args:     []*data.Value{{Value: "http://127.0.0.1:3000/items", Type: data.String}},
+				Strategy: CursorPagination,
+				Path:     Path{"page", "content", "meta", "next_cursor"},
+				Cursor:   "after",
+			},
+			current: "http://127.0.0.1:3000/items?after=20",
+			page: map[string]interface{}{
+				"content": map[string]interface{}{
+					"meta": map[string]interface{}{"next_cursor": ""},
+				},
+			},
+			expected: "",
+		},
+	} {
+		// The Paginators have no VM as we don't test filter blocks here.
+		err, next := test.paginator.next(test.current, &data.Value{Value: test.page, Type: data.Object})
+		if err != nil {
+			t.Errorf("error \"%s\" should not have occurred (testNo: %d)", err.Error(), testNo+1)
+		} else if next != test.expected {
+			t.Errorf("next %q for testNo: %d does not match the required next: %q", next, testNo+1, test.expected)
+		}
+	}
+}
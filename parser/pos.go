@@ -10,8 +10,10 @@ func (p *Program) GetPos() lexer.Position { return p.Pos }
 func (b *Block) GetPos() lexer.Position { return b.Pos }
 func (i *IfElifElse) GetPos() lexer.Position { return i.Pos }
 func (f *FunctionDefinition) GetPos() lexer.Position { return f.Pos }
+func (f *Function) GetPos() lexer.Position { return f.Pos }
 func (tc *TryCatch) GetPos() lexer.Position { return tc.Pos }
 func (b *Batch) GetPos() lexer.Position { return b.Pos }
+func (p *Parallel) GetPos() lexer.Position { return p.Pos }
 func (f *ForEach) GetPos() lexer.Position { return f.Pos }
 func (f *For) GetPos() lexer.Position { return f.Pos }
 func (w *While) GetPos() lexer.Position { return w.Pos }
@@ -19,9 +21,13 @@ func (t *TestStatement) GetPos() lexer.Position { return t.Pos }
 func (a *Assignment) GetPos() lexer.Position { return a.Pos }
 func (s *Statement) GetPos() lexer.Position { return s.Pos }
 func (j *JSONPath) GetPos() lexer.Position { return j.Pos }
+func (s *StringLit) GetPos() lexer.Position { return s.Pos }
+func (s *StringSegment) GetPos() lexer.Position { return s.Pos }
 func (r *ReturnStatement) GetPos() lexer.Position { return r.Pos }
 func (t *ThrowStatement) GetPos() lexer.Position { return t.Pos }
 func (f *FunctionCall) GetPos() lexer.Position { return f.Pos }
+func (a *Argument) GetPos() lexer.Position { return a.Pos }
+func (p *Parameter) GetPos() lexer.Position { return p.Pos }
 func (m *MethodCall) GetPos() lexer.Position { return m.Pos }
 func (e *Expression) GetPos() lexer.Position { return e.Pos }
 func (p5 *Prec5) GetPos() lexer.Position { return p5.Pos }
@@ -36,6 +42,8 @@ func (p1 *Prec1) GetPos() lexer.Position { return p1.Pos }
 func (p1t *Prec1Term) GetPos() lexer.Position { return p1t.Pos }
 func (p0 *Prec0) GetPos() lexer.Position { return p0.Pos }
 func (f *Factor) GetPos() lexer.Position { return f.Pos }
+func (u *Unary) GetPos() lexer.Position { return u.Pos }
+func (c *Conditional) GetPos() lexer.Position { return c.Pos }
 func (j *JSONPathFactor) GetPos() lexer.Position { return j.Pos }
 func (j *JSON) GetPos() lexer.Position { return j.Pos }
 func (n *Null) GetPos() lexer.Position { return lexer.Position{} }
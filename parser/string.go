@@ -53,15 +53,53 @@ func (i *IfElifElse) String(indent int) string {
 }
 
 func (f *FunctionDefinition) String(indent int) string {
-	return fmt.Sprintf("%sfun %s%s", tabs(indent), f.JSONPath.String(0), f.Body.String(0))
+	return fmt.Sprintf("%sfun %s%s", tabs(indent), f.Name(), f.Body.String(0))
+}
+
+func (f *Function) String(indent int) string {
+	return fmt.Sprintf("%sfun %s", tabs(indent), f.Body.String(0))
 }
 
 func (tc *TryCatch) String(indent int) string {
-	return fmt.Sprintf("%stry this\n%s%scatch as %s do\n%s%send", tabs(indent), tc.Try.String(indent+1), tabs(indent), *tc.CatchAs, tc.Caught.String(indent+1), tabs(indent))
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("%stry this\n%s", tabs(indent), tc.Try.String(indent+1)))
+	if tc.CatchAs != nil {
+		var filters string
+		if len(tc.Filters) > 0 {
+			filters = strings.Join(tc.Filters, ", ") + " "
+		}
+		b.WriteString(fmt.Sprintf("%scatch %sas %s do\n%s", tabs(indent), filters, *tc.CatchAs, tc.Caught.String(indent+1)))
+	}
+	if tc.Finally != nil {
+		b.WriteString(fmt.Sprintf("%sfinally do\n%s", tabs(indent), tc.Finally.String(indent+1)))
+	}
+	b.WriteString(tabs(indent) + "end")
+	return b.String()
 }
 
 func (b *Batch) String(indent int) string {
-	return fmt.Sprintf("%sbatch this\n%s%send", tabs(indent), b.Block.String(indent+1), tabs(indent))
+	var workers string
+	if b.Workers != nil {
+		workers = " with " + b.Workers.String(0)
+	}
+	if b.Within != nil {
+		workers += " within " + b.Within.String(0)
+	}
+	var policy string
+	if b.FailFast {
+		policy = " fail fast"
+	} else if b.Collect != nil {
+		policy = " collect into " + *b.Collect
+	}
+	return fmt.Sprintf("%sbatch this%s%s\n%s%send", tabs(indent), workers, policy, b.Block.String(indent+1), tabs(indent))
+}
+
+func (p *Parallel) String(indent int) string {
+	var workers string
+	if p.Workers != nil {
+		workers = " with " + p.Workers.String(0)
+	}
+	return fmt.Sprintf("%sparallel this%s\n%s%send", tabs(indent), workers, p.Block.String(indent+1), tabs(indent))
 }
 
 func (f *ForEach) String(indent int) string {
@@ -103,6 +141,8 @@ func (s *Statement) String(indent int) string {
 		stmt = s.MethodCall.String(indent)
 	case s.Break != nil:
 		stmt = "break"
+	case s.Continue != nil:
+		stmt = "continue"
 	case s.Test != nil:
 		stmt = s.Test.String(indent)
 	case s.While != nil:
@@ -113,6 +153,8 @@ func (s *Statement) String(indent int) string {
 		stmt = s.ForEach.String(indent)
 	case s.Batch != nil:
 		stmt = s.Batch.String(indent)
+	case s.Parallel != nil:
+		stmt = s.Parallel.String(indent)
 	case s.TryCatch != nil:
 		stmt = s.TryCatch.String(indent)
 	case s.FunctionDefinition != nil:
@@ -175,16 +217,7 @@ func (jp *JSONPart) String(indent int) string {
 }
 
 func (slp *StringLitPart) String(indent int) string {
-	var b strings.Builder
-	var part string
-	encoder := json.NewEncoder(&b)
-	encoder.SetEscapeHTML(false)
-	if err := encoder.Encode(*slp.StringLit); err != nil {
-		panic(err)
-	} else {
-		part = strings.TrimSuffix(b.String(), "\n")
-	}
-
+	part := slp.StringLit.String(0)
 	if len(slp.Indices) > 0 {
 		expressions := make([]string, len(slp.Indices))
 		for i, expression := range slp.Indices {
@@ -195,6 +228,47 @@ func (slp *StringLitPart) String(indent int) string {
 	return part
 }
 
+func (s *StringLit) String(indent int) string {
+	if s.Raw {
+		var text string
+		if len(s.Segments) > 0 {
+			text = s.Segments[0].Text
+		}
+		return `"""` + text + `"""`
+	}
+
+	var b strings.Builder
+	b.WriteString("\"")
+	for i, segment := range s.Segments {
+		if segment.Interpolation != nil {
+			// Quotes within the Expression are escaped, as they are within the rest of the string literal
+			expression := strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(segment.Interpolation.String(0))
+			b.WriteString("{{" + expression + "}}")
+			continue
+		}
+
+		var quoted strings.Builder
+		encoder := json.NewEncoder(&quoted)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(segment.Text); err != nil {
+			panic(err)
+		}
+		text := strings.TrimSuffix(quoted.String(), "\n")
+		text = text[1 : len(text)-1]
+
+		// Any brace that could be mistaken for the start of an interpolation is escaped
+		nextInterpolation := i+1 < len(s.Segments) && s.Segments[i+1].Interpolation != nil
+		for j := 0; j < len(text); j++ {
+			if text[j] == '{' && ((j+1 < len(text) && text[j+1] == '{') || (j+1 == len(text) && nextInterpolation)) {
+				b.WriteString("\\")
+			}
+			b.WriteByte(text[j])
+		}
+	}
+	b.WriteString("\"")
+	return b.String()
+}
+
 func (i *Index) String(indent int) string {
 	var indexOut string
 	switch {
@@ -234,6 +308,20 @@ func (f *FunctionCall) String(indent int) string {
 	return functionCall + ")"
 }
 
+func (a *Argument) String(indent int) string {
+	if a.Name != nil {
+		return fmt.Sprintf("%s = %s", *a.Name, a.Value.String(0))
+	}
+	return a.Value.String(0)
+}
+
+func (p *Parameter) String(indent int) string {
+	if p.Default != nil {
+		return fmt.Sprintf("%s = %s", p.JSONPath.String(0), p.Default.String(0))
+	}
+	return p.JSONPath.String(0)
+}
+
 func (m *MethodCall) String(indent int) string {
 	methodCall := fmt.Sprintf("%s$%s(", tabs(indent), m.Method.String())
 	if len(m.Arguments) > 0 {
@@ -251,6 +339,9 @@ func (fb *FunctionBody) String(indent int) string {
 	for i, param := range fb.Parameters {
 		params[i] = param.String(0)
 	}
+	if fb.Rest != nil {
+		params = append(params, "..."+fb.Rest.String(0))
+	}
 	return fmt.Sprintf("(%s)\n%send", strings.Join(params, ", "), fb.Block.String(indent+1))
 }
 
@@ -357,6 +448,12 @@ func (f *Factor) String(indent int) string {
 		fac = f.FunctionCall.String(0)
 	case f.MethodCall != nil:
 		fac = f.MethodCall.String(0)
+	case f.Function != nil:
+		fac = f.Function.String(0)
+	case f.Unary != nil:
+		fac = f.Unary.String(0)
+	case f.Conditional != nil:
+		fac = f.Conditional.String(0)
 	case f.SubExpression != nil:
 		fac = fmt.Sprintf("(%s)", f.SubExpression.String(0))
 	default:
@@ -365,6 +462,14 @@ func (f *Factor) String(indent int) string {
 	return fac
 }
 
+func (u *Unary) String(indent int) string {
+	return fmt.Sprintf("%s%s%s", tabs(indent), u.Operator.String(), u.Factor.String(0))
+}
+
+func (c *Conditional) String(indent int) string {
+	return fmt.Sprintf("%s(is %s ? %s else %s)", tabs(indent), c.Condition.String(0), c.Then.String(0), c.Else.String(0))
+}
+
 func (j *JSON) String(indent int) string {
 	if j.Object != nil {
 		return j.Object.String(indent)
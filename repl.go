@@ -187,15 +187,15 @@ func REPL() {
 			fmt.Println(fmt.Sprintf("Error occurred whilst executing input: %v", err))
 		}
 
-		if vm.GetStdout().(*strings.Builder).Len() != 0 {
+		if stdout.Len() != 0 {
 			fmt.Println("--- STDOUT ---")
-			fmt.Print(vm.GetStdout().(*strings.Builder).String())
+			fmt.Print(stdout.String())
 			fmt.Println("--------------")
 		}
 
-		if vm.GetStderr().(*strings.Builder).Len() != 0 {
+		if stderr.Len() != 0 {
 			fmt.Println("--- STDERR ---")
-			fmt.Print(vm.GetStderr().(*strings.Builder).String())
+			fmt.Print(stderr.String())
 			fmt.Println("--------------")
 		}
 
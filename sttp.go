@@ -8,7 +8,10 @@ import (
 )
 
 func main() {
-	if len(os.Args) > 1 {
+	if len(os.Args) > 1 && os.Args[1] == LoadCommand {
+		// Load tests have their own command line arguments
+		os.Exit(Load(os.Args[2:], os.Stdout, os.Stderr))
+	} else if len(os.Args) > 1 {
 		sourceFileOrScript := os.Args[1]
 		var filename, s string
 
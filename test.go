@@ -9,6 +9,7 @@ import (
 	"github.com/andygello555/parser"
 	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
 	"sort"
@@ -93,6 +94,10 @@ type TestResults struct {
 func (t *TestResults) Run(stdout io.Writer, stderr io.Writer, debug io.Writer, mergedEnv *Env) error {
 	var err error
 	vm := New(false, t, stdout, stderr, debug, mergedEnv)
+	// Every script within a TestSuite shares the same Memo
+	if t.Config != nil && t.Config.Memo != nil {
+		vm.Client.SetMemo(t.Config.Memo)
+	}
 	fileBytes, _ := ioutil.ReadFile(t.Path)
 	err, _ = vm.Eval(t.Path, string(fileBytes))
 	if err != nil {
@@ -263,6 +268,14 @@ func (ts *TestSuite) String(indent int) string {
 // os.Stderr, and ioutil.Discard respectively. It also takes a mergedEnv which can either be nil, or an environment that
 // has been passed down from a parent TestSuite.
 func (ts *TestSuite) Run(stdout io.Writer, stderr io.Writer, debug io.Writer, mergedEnv *Env) error {
+	// If memoisation is enabled, then the outermost TestSuite creates the Memo that is shared by every script within it.
+	// Any error will be written by the VM for each script.
+	if ts.NestLevel == 0 && ts.Config.Memo == nil {
+		if ttl, ok := os.LookupEnv(eval.MemoEnv); ok {
+			_, ts.Config.Memo = eval.ParseMemo(ttl)
+		}
+	}
+
 	if files, err := ioutil.ReadDir(ts.Path); err != nil {
 		return err
 	} else {
@@ -275,6 +288,7 @@ func (ts *TestSuite) Run(stdout io.Writer, stderr io.Writer, debug io.Writer, me
 			if file.IsDir() {
 				// Create a new test suite (don't run just yet)
 				newSuite := NewSuite(path, ts.Config.BreakOnFailure, ts.NestLevel+1)
+				newSuite.Config.Memo = ts.Config.Memo
 				ts.Paths = append(ts.Paths, &TestPath{
 					Path:      path,
 					TestSuite: newSuite,
@@ -342,6 +356,9 @@ func (ts *TestSuite) Run(stdout io.Writer, stderr io.Writer, debug io.Writer, me
 // TestConfig is passed to a TestSuite to describe which features are enabled within the TestSuite.
 type TestConfig struct {
 	BreakOnFailure bool
+	// Memo is the eval.Memo shared by every script within the TestSuite, and its nested TestSuites. If this is nil then
+	// each script uses the Memo, if any, that its VM was created with.
+	Memo *eval.Memo
 }
 
 // Get uses reflection to get the given TestConfig field by name. Will return nil if there is no such field.
@@ -1,15 +1,17 @@
 package main
 
 import (
-	"container/heap"
 	"fmt"
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/andygello555/data"
 	"github.com/andygello555/errors"
+	"github.com/andygello555/eval"
 	"github.com/andygello555/parser"
 	"io"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"sync"
 )
 
 // VM represents the current state of the sttp virtual machines.
@@ -31,15 +33,38 @@ type VM struct {
 	Debug io.Writer
 	// The BatchSuite used for parser.Batch statements. If nil then the VM is not currently in a parser.Batch statement.
 	Batch parser.BatchSuite
-	// BatchResults contains the results of the executed BatchSuite. If nil then the VM is not currently in a
-	// parser.Batch statement, or the BatchSuite has not yet been executed.
-	BatchResults heap.Interface
 	// All the environments passed to this VM when evaluating a script. This is so that inheritance can take place
 	// within TestSuites.
 	Environments []parser.Env
 	// Whether the VM is running in REPL mode. This will not remove the bottommost stack frame at the end of
 	// parser.Program Eval().
 	REPL bool
+	// Client is the eval.Client used to make all the HTTP method calls within the VM. It holds the VM's HTTP cache, if
+	// one is enabled, and the VM's resolve overrides.
+	Client *eval.Client
+	// BatchWorkers is the number of workers that are started for parser.Batch statements that do not give a number of
+	// workers. If this is less than 1 then DefaultWorkers is used.
+	BatchWorkers int
+	// Pool is the AsyncPool that executes the work started by the $async builtin. It has the same number of workers as
+	// BatchWorkers.
+	Pool *AsyncPool
+	// BatchProgress is whether parser.Batch statements should write a progress line to Stderr once they have been
+	// running for longer than ProgressDelay.
+	BatchProgress bool
+	// BatchStats is the summary of the last parser.Batch statement that was evaluated, as returned by BatchSuite.Stats.
+	// This is nil until a parser.Batch statement has been evaluated.
+	BatchStats *data.Value
+	// LoadStats records the latency of each HTTP method call made by the VM. If this is nil then nothing is recorded.
+	LoadStats *LoadStats
+	// Modules is the cache of the modules imported using the $import builtin. It is shared with every VM forked from
+	// the VM.
+	Modules *Modules
+	// importing is the stack of the absolute paths of the modules that are currently being imported. This is used to
+	// detect import cycles.
+	importing []string
+	// output is the lock that is held whilst writing to Stdout, Stderr, and Debug. It is nil until the VM is forked,
+	// after which it is shared with every VM forked from it.
+	output *sync.Mutex
 }
 
 func New(repl bool, testResults *TestResults, stdout io.Writer, stderr io.Writer, debug io.Writer, envs ...parser.Env) *VM {
@@ -54,17 +79,54 @@ func New(repl bool, testResults *TestResults, stdout io.Writer, stderr io.Writer
 	if debug == nil {
 		debug = ioutil.Discard
 	}
+
+	// Resolve overrides can be given for all VMs using the environment
+	client := eval.NewClient()
+	if err := client.ParseResolve(os.Getenv(eval.ResolveEnv)); err != nil {
+		_, _ = fmt.Fprintf(stderr, "invalid %s: %s\n", eval.ResolveEnv, err.Error())
+	}
+	// As can memoisation
+	if ttl, ok := os.LookupEnv(eval.MemoEnv); ok {
+		if err, memo := eval.ParseMemo(ttl); err != nil {
+			_, _ = fmt.Fprintf(stderr, "invalid %s: %s\n", eval.MemoEnv, err.Error())
+		} else {
+			client.SetMemo(memo)
+		}
+	}
+
+	// As can the default number of batch workers
+	var batchWorkers int
+	if workers, ok := os.LookupEnv(WorkersEnv); ok {
+		var err error
+		if batchWorkers, err = strconv.Atoi(workers); err != nil || batchWorkers < 1 {
+			_, _ = fmt.Fprintf(stderr, "invalid %s: %q is not a positive integer\n", WorkersEnv, workers)
+			batchWorkers = 0
+		}
+	}
+
+	// And whether batches should show their progress
+	var batchProgress bool
+	if progress, ok := os.LookupEnv(ProgressEnv); ok {
+		var err error
+		if batchProgress, err = strconv.ParseBool(progress); err != nil {
+			_, _ = fmt.Fprintf(stderr, "invalid %s: %q is not a boolean\n", ProgressEnv, progress)
+		}
+	}
 	return &VM{
-		Scope:        0,
-		CallStack:    &cs,
-		TestResults:  testResults,
-		Stdout:       stdout,
-		Stderr:       stderr,
-		Debug:        debug,
-		Batch:        nil,
-		BatchResults: nil,
-		Environments: envs,
-		REPL:         repl,
+		Scope:         0,
+		CallStack:     &cs,
+		TestResults:   testResults,
+		Stdout:        stdout,
+		Stderr:        stderr,
+		Debug:         debug,
+		Batch:         nil,
+		Environments:  envs,
+		REPL:          repl,
+		Client:        client,
+		BatchWorkers:  batchWorkers,
+		Pool:          NewAsyncPool(batchWorkers),
+		BatchProgress: batchProgress,
+		Modules:       NewModules(),
 	}
 }
 
@@ -127,11 +189,11 @@ func (vm *VM) GetTestResults() parser.TestResults {
 }
 
 func (vm *VM) GetStdout() io.Writer {
-	return vm.Stdout
+	return vm.locked(vm.Stdout)
 }
 
 func (vm *VM) GetStderr() io.Writer {
-	return vm.Stderr
+	return vm.locked(vm.Stderr)
 }
 
 func (vm *VM) SetStdout(stdout io.Writer) {
@@ -145,7 +207,7 @@ func (vm *VM) SetStderr(stderr io.Writer) {
 // GetDebug will return the io.Writer used for debugging. If the io.Writer is equal to ioutil.Discard, then false will
 // be returned, otherwise true will be returned.
 func (vm *VM) GetDebug() (io.Writer, bool) {
-	return vm.Debug, vm.Debug != ioutil.Discard
+	return vm.locked(vm.Debug), vm.Debug != ioutil.Discard
 }
 
 // WriteDebug will write to the Debug io.Writer if it exists, otherwise will be ignored.
@@ -155,27 +217,40 @@ func (vm *VM) WriteDebug(format string, a ...interface{}) {
 	}
 }
 
-func (vm *VM) GetBatch() (parser.BatchSuite, heap.Interface) {
-	return vm.Batch, vm.BatchResults
+func (vm *VM) GetBatch() parser.BatchSuite {
+	return vm.Batch
 }
 
-// DeleteBatch will stop the workers, then nullify the Batch.
+// DeleteBatch will stop the workers, keep the Batch's stats, then nullify the Batch.
 func (vm *VM) DeleteBatch() {
 	vm.Batch.Stop()
+	vm.BatchStats = vm.Batch.Stats()
 	vm.Batch = nil
-	vm.BatchResults = nil
 }
 
 func (vm *VM) CreateBatch(statement *parser.Batch) {
-	vm.Batch = Batch(statement)
+	batch := Batch(statement, vm.Client)
+	batch.Record = vm.RecordCall
+	if vm.BatchProgress {
+		batch.Progress = vm.GetStderr()
+	}
+	vm.Batch = batch
+}
+
+func (vm *VM) GetBatchStats() *data.Value {
+	return vm.BatchStats
 }
 
-func (vm *VM) StartBatch() {
-	vm.Batch.Start(-1)
+func (vm *VM) StartBatch(workers int) {
+	if workers < 1 {
+		workers = vm.BatchWorkers
+	}
+	vm.Batch.Start(workers)
 }
 
-func (vm *VM) StopBatch() {
-	vm.BatchResults = vm.Batch.Stop()
+func (vm *VM) StopBatch() error {
+	vm.Batch.Stop()
+	return vm.Batch.Err()
 }
 
 func (vm *VM) GetEnvironment() (err error, env parser.Env) {
@@ -197,3 +272,7 @@ func (vm *VM) GetEnvironment() (err error, env parser.Env) {
 func (vm *VM) CheckREPL() bool {
 	return vm.REPL
 }
+
+func (vm *VM) GetClient() *eval.Client {
+	return vm.Client
+}